@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"errors"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/log"
+	"github.com/azzill/goze/midware"
+	"github.com/azzill/goze/server"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SchemeBearer = "bearer"
+	SchemeAPIKey = "api-key"
+	SchemeBasic  = "basic"
+)
+
+// route metadata holding the roles required by RequireRole, any authenticated client if empty
+const MetaRoles = "auth.roles"
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+var logger = log.NewLogger("Auth")
+
+// the clock of token validation, replaced by the tests
+var now = time.Now
+
+// Authenticator authenticates the credentials of its scheme
+type Authenticator interface {
+	// nil, nil if the request carries no credentials of the scheme
+	Authenticate(r *http.Request) (*common.Principal, error)
+	// value of WWW-Authenticate asking for the credentials, not sent if empty
+	Challenge() string
+}
+
+// Auth is an interceptor storing the principal of the request in RequestCtx.Principal, the authenticators
+// are tried in order and the first one finding credentials decides. Invalid credentials are refused with
+// 401 Unauthorized, anonymous requests pass unless Required is set or the route is restricted by
+// RequireAuth or RequireRole, a principal without the roles of the route is refused with 403 Forbidden
+type Auth struct {
+	Authenticators []Authenticator
+	// refuse anonymous requests on every route
+	Required bool
+	// priority among the interceptors
+	Order int
+}
+
+func New(authenticators ...Authenticator) *Auth {
+	return &Auth{Authenticators: authenticators}
+}
+
+// only the authenticated requests reach the route
+func RequireAuth() server.RouteOption {
+	return server.Meta(MetaRoles, []string{})
+}
+
+// only the principals with any of the roles reach the route
+func RequireRole(roles ...string) server.RouteOption {
+	return server.Meta(MetaRoles, roles)
+}
+
+func (a *Auth) Priority() int {
+	return a.Order
+}
+
+func (a *Auth) Intercept(ctx *common.RequestCtx) (midware.InterceptorAction, interface{}) {
+	// authenticated by another Auth, eg: of the route group
+	if ctx.Principal == nil {
+		for _, authenticator := range a.Authenticators {
+			principal, e := authenticator.Authenticate(ctx.Request)
+			if e != nil {
+				logger.Info(ctx.Request.Method, ctx.Request.URL.Path, "- authentication failed:", e.Error())
+				a.challenge(ctx)
+				// the cause is only logged, it would tell attackers what to fix
+				return midware.Block, server.Unauthorized("invalid credentials").WithCode("invalid_credentials").Wrap(e)
+			}
+			if principal != nil {
+				ctx.Principal = principal
+				break
+			}
+		}
+	}
+
+	roles, restricted := ctx.Metadata[MetaRoles].([]string)
+	if ctx.Principal == nil {
+		if a.Required || restricted {
+			a.challenge(ctx)
+			return midware.Block, server.Unauthorized("authentication required").WithCode("unauthenticated")
+		}
+		return midware.Continue, nil
+	}
+	if len(roles) == 0 {
+		return midware.Continue, nil
+	}
+	for _, role := range roles {
+		if ctx.Principal.HasRole(role) {
+			return midware.Continue, nil
+		}
+	}
+	return midware.Block, server.Forbidden("requires role " + strings.Join(roles, " or ")).WithCode("forbidden")
+}
+
+func (a *Auth) challenge(ctx *common.RequestCtx) {
+	for _, authenticator := range a.Authenticators {
+		if challenge := authenticator.Challenge(); challenge != "" {
+			ctx.ResponseWriter.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+}
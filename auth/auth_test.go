@@ -0,0 +1,156 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"encoding/json"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/server"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitListening(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		if c, e := net.Dial("tcp", addr); e == nil {
+			_ = c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(addr, "is not listening")
+}
+
+func TestAuth(t *testing.T) {
+	secret := []byte("secret")
+	users := map[string]string{"azz": "pass"}
+	a := New(
+		&JWT{Keys: Secret(secret)},
+		&APIKey{Lookup: StaticKeys(map[string]*common.Principal{
+			"key": {Subject: "robot", Roles: []string{"reader"}},
+		})},
+		&Basic{Realm: "goze", Verify: func(username string, password string) (*common.Principal, error) {
+			if p, ok := users[username]; !ok || p != password {
+				return nil, nil
+			}
+			return &common.Principal{Subject: username, Roles: []string{"admin"}}, nil
+		}},
+	)
+
+	addr := freeAddr(t)
+	s := server.NewRestServer(addr, &server.HttpConfig{})
+	s.AddInterceptor(a)
+	whoami := func(ctx *common.RequestCtx) interface{} {
+		if ctx.Principal == nil {
+			return "anonymous"
+		}
+		return ctx.Principal.Scheme + ":" + ctx.Principal.Subject
+	}
+	s.GET("/public", whoami)
+	s.GET("/private", whoami, RequireAuth())
+	s.GET("/admin", whoami, RequireRole("admin", "root"))
+	s.StartServerAsync()
+	defer s.Lifecycle().Shutdown()
+	waitListening(t, addr)
+
+	token := sign(t, HS256, "", secret, map[string]interface{}{"sub": "jwt", "roles": "admin"})
+	cases := []struct {
+		path      string
+		header    map[string]string
+		status    int
+		body      string
+		challenge bool
+	}{
+		{"/public", nil, 200, "anonymous", false},
+		{"/private", nil, 401, "unauthenticated", true},
+		{"/private", map[string]string{"X-Api-Key": "key"}, 200, "api-key:robot", false},
+		{"/private", map[string]string{"X-Api-Key": "wrong"}, 401, "invalid_credentials", true},
+		{"/public", map[string]string{"X-Api-Key": "wrong"}, 401, "invalid_credentials", true},
+		{"/admin", map[string]string{"X-Api-Key": "key"}, 403, "forbidden", false},
+		{"/admin", map[string]string{"Authorization": "Bearer " + token}, 200, "bearer:jwt", false},
+		{"/admin", map[string]string{"Authorization": "Bearer " + token + "x"}, 401, "invalid_credentials", true},
+		{"/admin", map[string]string{"Authorization": "Basic YXp6OnBhc3M="}, 200, "basic:azz", false},
+		{"/admin", map[string]string{"Authorization": "Basic YXp6Ondyb25n"}, 401, "invalid_credentials", true},
+	}
+	for _, c := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "http://"+addr+c.path, nil)
+		for k, v := range c.header {
+			r.Header.Set(k, v)
+		}
+		resp, e := http.DefaultClient.Do(r)
+		if e != nil {
+			t.Fatal(e)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || !strings.Contains(string(body), c.body) {
+			t.Error(c.path, c.header, "responds", resp.StatusCode, string(body))
+		}
+		if c.body == "invalid_credentials" && !strings.Contains(string(body), `"detail":"invalid credentials"`) {
+			t.Error(c.path, c.header, "tells the cause", string(body))
+		}
+		challenges := resp.Header.Values("WWW-Authenticate")
+		if c.challenge != (len(challenges) == 2) {
+			t.Error(c.path, c.header, "challenges", challenges)
+		}
+		if c.challenge && (challenges[0] != "Bearer" || challenges[1] != `Basic realm="goze", charset="UTF-8"`) {
+			t.Error("unexpected challenges", challenges)
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+	a := New(&APIKey{Header: "X-Token", Lookup: func(key string) (*common.Principal, error) {
+		return &common.Principal{Subject: key}, nil
+	}})
+	a.Required = true
+
+	addr := freeAddr(t)
+	s := server.NewRestServer(addr, &server.HttpConfig{})
+	s.AddInterceptor(a)
+	s.GET("/claims", func(ctx *common.RequestCtx) interface{} {
+		return ctx.Principal
+	})
+	s.StartServerAsync()
+	defer s.Lifecycle().Shutdown()
+	waitListening(t, addr)
+
+	resp, e := http.Get("http://" + addr + "/claims")
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 || resp.Header.Get("WWW-Authenticate") != "" {
+		t.Error("anonymous request is accepted", resp.StatusCode)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/claims", nil)
+	r.Header.Set("X-Token", "azz")
+	resp, e = http.DefaultClient.Do(r)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer resp.Body.Close()
+	principal := common.Principal{}
+	_ = json.NewDecoder(resp.Body).Decode(&principal)
+	if resp.StatusCode != 200 || principal.Subject != "azz" || principal.Scheme != SchemeAPIKey {
+		t.Error("principal is not stored", resp.StatusCode, principal)
+	}
+}
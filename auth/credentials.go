@@ -0,0 +1,90 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"crypto/subtle"
+	"github.com/azzill/goze/common"
+	"net/http"
+	"strconv"
+)
+
+const DefaultAPIKeyHeader = "X-Api-Key"
+
+// APIKey authenticates the key sent in a header
+type APIKey struct {
+	// DefaultAPIKeyHeader if empty
+	Header string
+	// the principal of the key, nil if the key is unknown
+	Lookup func(key string) (*common.Principal, error)
+}
+
+func (a *APIKey) Authenticate(r *http.Request) (*common.Principal, error) {
+	header := a.Header
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	key := r.Header.Get(header)
+	if key == "" {
+		return nil, nil
+	}
+	principal, e := a.Lookup(key)
+	return withScheme(principal, e, SchemeAPIKey)
+}
+
+// api keys have no standard challenge
+func (a *APIKey) Challenge() string {
+	return ""
+}
+
+// lookup of a fixed set of keys, every key is compared in constant time
+func StaticKeys(keys map[string]*common.Principal) func(key string) (*common.Principal, error) {
+	return func(key string) (*common.Principal, error) {
+		var found *common.Principal
+		for k, principal := range keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				found = principal
+			}
+		}
+		return found, nil
+	}
+}
+
+// Basic authenticates the username and password of HTTP Basic authentication
+type Basic struct {
+	Realm string
+	// the principal of the user, nil if the password does not match
+	Verify func(username string, password string) (*common.Principal, error)
+}
+
+func (b *Basic) Authenticate(r *http.Request) (*common.Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	principal, e := b.Verify(username, password)
+	return withScheme(principal, e, SchemeBasic)
+}
+
+func (b *Basic) Challenge() string {
+	return "Basic realm=" + strconv.Quote(b.Realm) + `, charset="UTF-8"`
+}
+
+// a copy of the principal found by a lookup with the scheme set, so that shared principals are not changed
+func withScheme(principal *common.Principal, e error, scheme string) (*common.Principal, error) {
+	if e != nil {
+		return nil, e
+	}
+	if principal == nil {
+		return nil, ErrInvalidCredentials
+	}
+	p := *principal
+	if p.Scheme == "" {
+		p.Scheme = scheme
+	}
+	return &p, nil
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// min interval between the fetches of a key set from a url, eg: when tokens name unknown keys
+const jwksMinRefresh = 10 * time.Second
+
+// KeySet finds the key verifying a token by the kid and alg of its header
+type KeySet interface {
+	Key(kid string, alg string) (interface{}, error)
+}
+
+type secretKey []byte
+
+// the shared secret of HS256
+func Secret(secret []byte) KeySet {
+	return secretKey(secret)
+}
+
+func (k secretKey) Key(kid string, alg string) (interface{}, error) {
+	return []byte(k), nil
+}
+
+type publicKey struct {
+	key crypto.PublicKey
+}
+
+// a single *rsa.PublicKey or *ecdsa.PublicKey
+func PublicKey(key crypto.PublicKey) KeySet {
+	return publicKey{key: key}
+}
+
+func (k publicKey) Key(kid string, alg string) (interface{}, error) {
+	return k.key, nil
+}
+
+// JWKS is a JSON Web Key Set, keys are found by kid or by alg if the token names no kid
+type JWKS struct {
+	keys []jwk
+}
+
+type jwk struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// rsa
+	N string `json:"n"`
+	E string `json:"e"`
+	// ec
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+// keys of other types or uses than signing are skipped
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	if e := json.Unmarshal(data, &set); e != nil {
+		return nil, fmt.Errorf("invalid jwks: %v", e)
+	}
+	jwks := &JWKS{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, e := k.publicKey()
+		if e != nil {
+			return nil, fmt.Errorf("invalid key `%s`: %v", k.Kid, e)
+		}
+		if key != nil {
+			jwks.keys = append(jwks.keys, jwk{kid: k.Kid, alg: k.Alg, key: key})
+		}
+	}
+	return jwks, nil
+}
+
+func LoadJWKSFile(path string) (*JWKS, error) {
+	data, e := os.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	return ParseJWKS(data)
+}
+
+func (k jwkJSON) publicKey() (interface{}, error) {
+	decode := func(s string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	switch k.Kty {
+	case "RSA":
+		n, e := decode(k.N)
+		if e != nil {
+			return nil, e
+		}
+		exponent, e := decode(k.E)
+		if e != nil {
+			return nil, e
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, e := decode(k.X)
+		if e != nil {
+			return nil, e
+		}
+		y, e := decode(k.Y)
+		if e != nil {
+			return nil, e
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
+		return key, nil
+	case "oct":
+		return decode(k.K)
+	default:
+		return nil, nil
+	}
+}
+
+func (s *JWKS) Key(kid string, alg string) (interface{}, error) {
+	for _, k := range s.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg || !verifies(k.key, alg) {
+			continue
+		}
+		return k.key, nil
+	}
+	return nil, fmt.Errorf("key `%s` is not found", kid)
+}
+
+// whether the type of the key matches the algorithm, so that a token naming no kid finds
+// the first key of its algorithm even if the keys declare none
+func verifies(key interface{}, alg string) bool {
+	switch key.(type) {
+	case []byte:
+		return alg == HS256
+	case *rsa.PublicKey:
+		return alg == RS256
+	case *ecdsa.PublicKey:
+		return alg == ES256
+	}
+	return false
+}
+
+// RemoteJWKS is a key set fetched from a url, eg: of the identity provider, it is fetched again
+// after Refresh, or when a token names an unknown key, so that rotated keys are found.
+// The cached keys are served while they are refreshed, only the requests naming unknown keys wait
+type RemoteJWKS struct {
+	URL     string
+	Refresh time.Duration
+	Client  *http.Client
+
+	mu        sync.Mutex
+	keys      *JWKS
+	fetched   time.Time
+	attempted time.Time
+	// closed when the fetch in flight ends, nil if there is none
+	fetching chan struct{}
+}
+
+func NewRemoteJWKS(url string, refresh time.Duration) *RemoteJWKS {
+	return &RemoteJWKS{URL: url, Refresh: refresh, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *RemoteJWKS) Key(kid string, alg string) (interface{}, error) {
+	t := now()
+	s.mu.Lock()
+	keys := s.keys
+	stale := keys == nil || s.Refresh > 0 && t.Sub(s.fetched) >= s.Refresh
+	s.mu.Unlock()
+	if stale {
+		// waited for only if nothing is fetched yet
+		keys = s.refresh(t, keys == nil)
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("jwks of %s is not available", s.URL)
+	}
+	key, e := keys.Key(kid, alg)
+	if e != nil {
+		// the key may be rotated
+		if fresh := s.refresh(t, true); fresh != nil && fresh != keys {
+			return fresh.Key(kid, alg)
+		}
+	}
+	return key, e
+}
+
+// start a fetch unless one is in flight or the last one is within jwksMinRefresh, the fetch in flight
+// is waited for if wait is set, the latest keys are returned
+func (s *RemoteJWKS) refresh(t time.Time, wait bool) *JWKS {
+	s.mu.Lock()
+	done := s.fetching
+	if done == nil && s.canFetch(t) {
+		done = make(chan struct{})
+		s.fetching, s.attempted = done, t
+		go s.update(t, done)
+	}
+	keys := s.keys
+	s.mu.Unlock()
+	if !wait || done == nil {
+		return keys
+	}
+
+	<-done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys
+}
+
+// the url is not fetched more often than jwksMinRefresh, even if it fails
+func (s *RemoteJWKS) canFetch(t time.Time) bool {
+	return s.attempted.IsZero() || t.Sub(s.attempted) >= jwksMinRefresh
+}
+
+// the keys fetched before are kept if it fails
+func (s *RemoteJWKS) update(t time.Time, done chan struct{}) {
+	keys, e := s.fetch()
+	s.mu.Lock()
+	if e == nil {
+		s.keys, s.fetched = keys, t
+	}
+	s.fetching = nil
+	s.mu.Unlock()
+	close(done)
+}
+
+func (s *RemoteJWKS) fetch() (*JWKS, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, e := client.Get(s.URL)
+	if e != nil {
+		logger.Warn("Failed to fetch jwks", s.URL, "-", e.Error())
+		return nil, e
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := fmt.Errorf("failed to fetch jwks: %s", resp.Status)
+		logger.Warn(e.Error())
+		return nil, e
+	}
+	data, e := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if e != nil {
+		return nil, e
+	}
+	keys, e := ParseJWKS(data)
+	if e != nil {
+		logger.Warn("Failed to parse jwks", s.URL, "-", e.Error())
+		return nil, e
+	}
+	return keys, nil
+}
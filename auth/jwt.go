@@ -0,0 +1,225 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrTokenExpired   = errors.New("token is expired")
+	ErrTokenNotValid  = errors.New("token is not valid yet")
+)
+
+// JWT authenticates the bearer tokens signed with HS256, RS256 or ES256
+type JWT struct {
+	Keys KeySet
+	// the algorithms accepted, all the supported ones if empty
+	Algorithms []string
+	// the iss claim must match if set
+	Issuer string
+	// the aud claim must contain it if set
+	Audience string
+	// clock skew tolerated by exp and nbf
+	Leeway time.Duration
+	// claim holding the roles as a list or a space separated string, "roles" if empty
+	RolesClaim string
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*common.Principal, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, nil
+	}
+	claims, e := j.Verify(strings.TrimSpace(header[7:]))
+	if e != nil {
+		return nil, e
+	}
+	principal := &common.Principal{Scheme: SchemeBearer, Claims: claims}
+	principal.Subject, _ = claims["sub"].(string)
+	rolesClaim := j.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	switch roles := claims[rolesClaim].(type) {
+	case string:
+		principal.Roles = strings.Fields(roles)
+	case []interface{}:
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, s)
+			}
+		}
+	}
+	return principal, nil
+}
+
+func (j *JWT) Challenge() string {
+	return "Bearer"
+}
+
+// verify the signature and the registered claims of the token, the claims are returned if it is valid
+func (j *JWT) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if e := decodeSegment(parts[0], &header); e != nil {
+		return nil, e
+	}
+	if !j.accepts(header.Alg) {
+		return nil, fmt.Errorf("algorithm `%s` is not accepted", header.Alg)
+	}
+	key, e := j.Keys.Key(header.Kid, header.Alg)
+	if e != nil {
+		return nil, e
+	}
+	signature, e := base64.RawURLEncoding.DecodeString(parts[2])
+	if e != nil {
+		return nil, ErrMalformedToken
+	}
+	if e := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); e != nil {
+		return nil, e
+	}
+
+	claims := map[string]interface{}{}
+	if e := decodeSegment(parts[1], &claims); e != nil {
+		return nil, e
+	}
+	if e := j.validate(claims); e != nil {
+		return nil, e
+	}
+	return claims, nil
+}
+
+func (j *JWT) accepts(alg string) bool {
+	algorithms := j.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{HS256, RS256, ES256}
+	}
+	for _, a := range algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *JWT) validate(claims map[string]interface{}) error {
+	t := now()
+	if exp, ok := claims["exp"].(float64); ok && !t.Before(unixTime(exp).Add(j.Leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && t.Before(unixTime(nbf).Add(-j.Leeway)) {
+		return ErrTokenNotValid
+	}
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if j.Audience != "" && !hasAudience(claims["aud"], j.Audience) {
+		return fmt.Errorf("token is not issued for %s", j.Audience)
+	}
+	return nil
+}
+
+// aud is a string or a list of strings
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, e := base64.RawURLEncoding.DecodeString(segment)
+	if e != nil {
+		return ErrMalformedToken
+	}
+	if e := json.Unmarshal(b, v); e != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
+
+// the type of the key must match the algorithm, so that a public key is never used as a hmac secret
+func verifySignature(alg string, key interface{}, signed []byte, signature []byte) error {
+	invalid := errors.New("invalid signature")
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key of %s must be a secret", alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return invalid
+		}
+	case RS256:
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key of %s must be a rsa public key", alg)
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) != nil {
+			return invalid
+		}
+	case ES256:
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok || public.Curve != elliptic.P256() {
+			return fmt.Errorf("key of %s must be a P-256 public key", alg)
+		}
+		// r and s of 32 bytes each
+		if len(signature) != 64 {
+			return invalid
+		}
+		digest := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(public, digest[:], r, s) {
+			return invalid
+		}
+	default:
+		return fmt.Errorf("algorithm `%s` is not supported", alg)
+	}
+	return nil
+}
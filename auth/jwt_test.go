@@ -0,0 +1,231 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sign(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case RS256:
+		s, e := rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if e != nil {
+			t.Fatal(e)
+		}
+		signature = s
+	case ES256:
+		r, s, e := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if e != nil {
+			t.Fatal(e)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "alg": RS256, "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32)))}
+}
+
+func jwks(keys ...map[string]string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return b
+}
+
+func setClock(t *testing.T, clock *time.Time) {
+	now = func() time.Time {
+		return *clock
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+}
+
+func TestJWT(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	setClock(t, &clock)
+	secret := []byte("secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if e := os.WriteFile(path, jwks(rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey)), 0600); e != nil {
+		t.Fatal(e)
+	}
+	keys, e := LoadJWKSFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	j := &JWT{Keys: keys, Issuer: "goze", Audience: "api", Leeway: 5 * time.Second}
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "azz", "iss": "goze", "aud": []string{"web", "api"}, "exp": 1100, "nbf": 990,
+			"roles": []string{"admin", "user"}}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, token := range []string{sign(t, RS256, "rsa", rsaKey, claims(nil)), sign(t, ES256, "ec", ecKey, claims(nil))} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		principal, e := j.Authenticate(r)
+		if e != nil || principal.Subject != "azz" || !principal.HasRole("admin") || principal.Scheme != SchemeBearer {
+			t.Error("token is not authenticated", principal, e)
+		}
+	}
+	if _, e := (&JWT{Keys: Secret(secret)}).Verify(sign(t, HS256, "", secret, claims(nil))); e != nil {
+		t.Error("hmac token is not verified", e)
+	}
+
+	// a token naming no kid is verified by the first key of the type of its algorithm
+	untyped := rsaJWK("", &rsaKey.PublicKey)
+	delete(untyped, "alg")
+	mixed, e := ParseJWKS(jwks(ecJWK("", &ecKey.PublicKey), untyped))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := (&JWT{Keys: mixed}).Verify(sign(t, RS256, "", rsaKey, claims(nil))); e != nil {
+		t.Error("key of the algorithm is not found", e)
+	}
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	invalid := map[string]string{
+		"expired":         sign(t, RS256, "rsa", rsaKey, claims(map[string]interface{}{"exp": 995})),
+		"not valid yet":   sign(t, RS256, "rsa", rsaKey, claims(map[string]interface{}{"nbf": 1010})),
+		"other audience":  sign(t, RS256, "rsa", rsaKey, claims(map[string]interface{}{"aud": "web"})),
+		"other issuer":    sign(t, RS256, "rsa", rsaKey, claims(map[string]interface{}{"iss": "evil"})),
+		"unknown key":     sign(t, RS256, "other", rsaKey, claims(nil)),
+		"wrong signature": sign(t, ES256, "rsa", ecKey, claims(nil)),
+		"tampered":        strings.Replace(sign(t, RS256, "rsa", rsaKey, claims(nil)), ".", ".e30", 1),
+		"none":            none + "." + strings.Split(sign(t, HS256, "", secret, claims(nil)), ".")[1] + ".",
+		"malformed":       "a.b",
+	}
+	for name, token := range invalid {
+		if _, e := j.Verify(token); e == nil {
+			t.Error(name, "token is accepted")
+		}
+	}
+	// a public key is never used as a hmac secret
+	public, _ := json.Marshal(rsaKey.PublicKey)
+	if _, e := (&JWT{Keys: PublicKey(&rsaKey.PublicKey)}).Verify(sign(t, HS256, "", public, claims(nil))); e == nil {
+		t.Error("algorithm confusion is accepted")
+	}
+	if _, e := (&JWT{Keys: Secret(secret), Algorithms: []string{RS256}}).Verify(sign(t, HS256, "", secret, claims(nil))); e == nil {
+		t.Error("algorithm which is not accepted is verified")
+	}
+	// within the leeway
+	clock = time.Unix(1103, 0)
+	if _, e := j.Verify(sign(t, RS256, "rsa", rsaKey, claims(nil))); e != nil {
+		t.Error("leeway is not applied", e)
+	}
+	clock = time.Unix(1105, 0)
+	if _, e := j.Verify(sign(t, RS256, "rsa", rsaKey, claims(nil))); !errors.Is(e, ErrTokenExpired) {
+		t.Error("expired token is accepted", e)
+	}
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	setClock(t, &clock)
+	first, _ := rsa.GenerateKey(rand.Reader, 2048)
+	second, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var fetches int32
+	var rotated atomic.Bool
+	// the identity provider hangs until it is released
+	slow := make(chan struct{})
+	close(slow)
+	idp := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-slow
+		if rotated.Load() {
+			_, _ = wr.Write(jwks(rsaJWK("second", &second.PublicKey)))
+			return
+		}
+		_, _ = wr.Write(jwks(rsaJWK("first", &first.PublicKey)))
+	}))
+	defer idp.Close()
+
+	j := &JWT{Keys: NewRemoteJWKS(idp.URL, time.Hour)}
+	claims := map[string]interface{}{"sub": "azz"}
+	for i := 0; i < 3; i++ {
+		if _, e := j.Verify(sign(t, RS256, "first", first, claims)); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if atomic.LoadInt32(&fetches) != 1 {
+		t.Error("jwks is fetched", fetches, "times")
+	}
+
+	// the key is rotated, unknown keys are fetched at most every jwksMinRefresh
+	rotated.Store(true)
+	token := sign(t, RS256, "second", second, claims)
+	if _, e := j.Verify(token); e == nil || atomic.LoadInt32(&fetches) != 1 {
+		t.Error("jwks is fetched too often", fetches, e)
+	}
+	clock = clock.Add(jwksMinRefresh)
+	if _, e := j.Verify(token); e != nil || atomic.LoadInt32(&fetches) != 2 {
+		t.Error("rotated key is not fetched", fetches, e)
+	}
+	if _, e := j.Verify(sign(t, RS256, "first", first, claims)); e == nil {
+		t.Error("removed key is accepted")
+	}
+
+	// the cached keys are served while a slow provider refreshes them
+	slow = make(chan struct{})
+	defer close(slow)
+	clock = clock.Add(time.Hour)
+	verified := make(chan error, 1)
+	go func() {
+		_, e := j.Verify(token)
+		verified <- e
+	}()
+	select {
+	case e := <-verified:
+		if e != nil {
+			t.Error("cached key is not served", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("requests wait for the refresh")
+	}
+}
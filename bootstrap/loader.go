@@ -7,8 +7,10 @@
 package bootstrap
 
 import (
+	"fmt"
 	"github.com/azzill/goze/balancer"
 	"github.com/azzill/goze/cache"
+	"github.com/azzill/goze/codec"
 	"github.com/azzill/goze/config"
 	"github.com/azzill/goze/context"
 	"github.com/azzill/goze/log"
@@ -16,6 +18,8 @@ import (
 	"github.com/azzill/goze/sql"
 	"github.com/azzill/goze/util"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 )
 
@@ -38,6 +42,24 @@ const (
 	defHttpReadHeaderTimeout = 10
 	defHttpIdleTimeout       = 5
 	defHttpWriteTimeout      = 10
+	defHttpExposeRoutes      = false
+	defHttpDrainTimeout      = 30
+	defHttpRestartTimeout    = 30
+	defHttpMaxBodyBytes      = 32 << 20
+	defMultipartMaxMemory    = 32 << 20
+	defMultipartMaxFileBytes = 0
+	defMultipartMaxFiles     = 0
+	defTLSCertFile           = ""
+	defTLSKeyFile            = ""
+	defTLSMinVersion         = "1.2"
+	defTLSClientCAFile       = ""
+	defTLSClientAuth         = ""
+	defTLSRedirectAddress    = ""
+	defTLSHTTP2              = true
+	defViewDir               = ""
+	defViewExt               = ".html"
+	defViewLayout            = ""
+	defViewReload            = false
 	defWRRBalancerTimeout    = 10
 	defBalancerRule          = balancer.WeightedRoundRobinRule
 	defSQLDataSource         = ""
@@ -85,6 +107,28 @@ type RestConfiguration struct {
 	WriteTimeout      time.Duration
 	IdleTimeout       time.Duration
 	MaxHeaderBytes    int
+	// max time to wait for the active requests when shutting down
+	DrainTimeout time.Duration
+	TLS          server.TLSConfig
+	// served besides ServerAddr, eg: unix sockets, sockets of systemd or an admin port
+	Listeners []server.ListenerConfig
+	// max time to wait for the new process on a hot restart (SIGHUP or SIGUSR2)
+	RestartTimeout time.Duration
+	// max bytes of request bodies, server.BodyLimit overrides it for a route
+	MaxBodyBytes int64
+	Multipart    codec.Multipart
+	// serve the route table at server.RoutesEndpoint
+	ExposeRoutes bool
+	View         ViewConfiguration
+}
+
+type ViewConfiguration struct {
+	// template directory, views are disabled if empty
+	Dir    string
+	Ext    string
+	Layout string
+	// reload the templates on every render, for development
+	Reload bool
 }
 
 type SQLConfiguration struct {
@@ -101,7 +145,10 @@ func StartGozeApplication(components ...interface{}) {
 		ctx.With(comp)
 	}
 	ctx.Inject()
-	ctx.GetComponent("RestServer").(*server.RestServer).StartServer()
+	restServer := ctx.GetComponent("RestServer").(*server.RestServer)
+	// resources are released after the server is drained on SIGINT or SIGTERM
+	ctx.RegisterShutdownHooks(restServer.Lifecycle())
+	restServer.StartServer()
 }
 
 func Bootstrap(configPath string) *context.ApplicationContext {
@@ -120,6 +167,14 @@ func Bootstrap(configPath string) *context.ApplicationContext {
 	//}
 
 	restServer := server.NewRestServer(cfg.Server.ServerAddr, httpConfig)
+	restServer.Codecs().Register(cfg.Server.Multipart)
+	if cfg.Server.ExposeRoutes {
+		restServer.ExposeRoutes(server.RoutesEndpoint)
+	}
+	if cfg.Server.View.Dir != "" {
+		restServer.SetViewEngine(server.NewViewEngine(os.DirFS(cfg.Server.View.Dir), server.ViewConfig{
+			Ext: cfg.Server.View.Ext, Layout: cfg.Server.View.Layout, Reload: cfg.Server.View.Reload}))
+	}
 	//microService := discover.NewWeightedMicroService(cfg.MicroService.ServiceName,
 	//	uint(port), cfg.MicroService.Weight)
 	redis := cache.NewRedisClient(cfg.Cache.Network, cfg.Cache.Address, cfg.Cache.Password, cfg.Cache.WriteTimeout,
@@ -148,6 +203,25 @@ func loadConfiguration(cfg *config.CommonConfiguration) *Configuration {
 	configs.Server.WriteTimeout = time.Duration(cfg.DefaultGet("goze.server.write-timeout", defHttpWriteTimeout).(int)) * time.Second
 	configs.Server.IdleTimeout = time.Duration(cfg.DefaultGet("goze.server.idle-timeout", defHttpIdleTimeout).(int)) * time.Second
 	configs.Server.MaxHeaderBytes = cfg.DefaultGet("goze.server.max-header-bytes", http.DefaultMaxHeaderBytes).(int)
+	configs.Server.DrainTimeout = time.Duration(cfg.DefaultGet("goze.server.drain-timeout", defHttpDrainTimeout).(int)) * time.Second
+	configs.Server.TLS.CertFile = cfg.DefaultGet("goze.server.tls.cert-file", defTLSCertFile).(string)
+	configs.Server.TLS.KeyFile = cfg.DefaultGet("goze.server.tls.key-file", defTLSKeyFile).(string)
+	configs.Server.TLS.MinVersion = versionString(cfg.DefaultGet("goze.server.tls.min-version", defTLSMinVersion))
+	configs.Server.TLS.ClientCAFile = cfg.DefaultGet("goze.server.tls.client-ca-file", defTLSClientCAFile).(string)
+	configs.Server.TLS.ClientAuth = cfg.DefaultGet("goze.server.tls.client-auth", defTLSClientAuth).(string)
+	configs.Server.TLS.RedirectAddr = cfg.DefaultGet("goze.server.tls.redirect-address", defTLSRedirectAddress).(string)
+	configs.Server.TLS.DisableHTTP2 = !cfg.DefaultGet("goze.server.tls.http2", defTLSHTTP2).(bool)
+	configs.Server.MaxBodyBytes = int64(cfg.DefaultGet("goze.server.max-body-bytes", defHttpMaxBodyBytes).(int))
+	configs.Server.Multipart.MaxMemory = int64(cfg.DefaultGet("goze.server.multipart.max-memory", defMultipartMaxMemory).(int))
+	configs.Server.Multipart.MaxFileSize = int64(cfg.DefaultGet("goze.server.multipart.max-file-bytes", defMultipartMaxFileBytes).(int))
+	configs.Server.Multipart.MaxFiles = cfg.DefaultGet("goze.server.multipart.max-files", defMultipartMaxFiles).(int)
+	configs.Server.RestartTimeout = time.Duration(cfg.DefaultGet("goze.server.restart-timeout", defHttpRestartTimeout).(int)) * time.Second
+	configs.Server.Listeners = listenerConfigs(cfg.Get("goze.server.listeners"))
+	configs.Server.ExposeRoutes = cfg.DefaultGet("goze.server.expose-routes", defHttpExposeRoutes).(bool)
+	configs.Server.View.Dir = cfg.DefaultGet("goze.server.view.dir", defViewDir).(string)
+	configs.Server.View.Ext = cfg.DefaultGet("goze.server.view.ext", defViewExt).(string)
+	configs.Server.View.Layout = cfg.DefaultGet("goze.server.view.layout", defViewLayout).(string)
+	configs.Server.View.Reload = cfg.DefaultGet("goze.server.view.reload", defViewReload).(bool)
 
 	//Cache
 	configs.Cache.Address = cfg.DefaultGet("goze.cache.redis.address", defRedisAddress).(string)
@@ -177,3 +251,53 @@ func loadConfiguration(cfg *config.CommonConfiguration) *Configuration {
 
 	return configs
 }
+
+// versions like 1.2 are read as numbers by yaml
+func versionString(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', 1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// goze.server.listeners is a list of network, address, route-set, tls and mode
+func listenerConfigs(v interface{}) []server.ListenerConfig {
+	items, ok := v.([]interface{})
+	if v != nil && !ok {
+		panic("goze.server.listeners must be a list")
+	}
+	listeners := make([]server.ListenerConfig, 0, len(items))
+	for i, item := range items {
+		values, ok := item.(map[interface{}]interface{})
+		if !ok {
+			panic(fmt.Sprintf("goze.server.listeners[%d] must be a map", i))
+		}
+		l := server.ListenerConfig{}
+		if network, has := values["network"]; has {
+			l.Network = fmt.Sprint(network)
+		}
+		if address, has := values["address"]; has {
+			l.Address = fmt.Sprint(address)
+		}
+		if set, has := values["route-set"]; has {
+			l.RouteSet = fmt.Sprint(set)
+		}
+		if tls, has := values["tls"].(bool); has {
+			l.TLS = tls
+		}
+		switch mode := values["mode"].(type) {
+		case nil:
+		// 0660 is read as an octal number by yaml
+		case int:
+			l.Mode = os.FileMode(mode)
+		default:
+			m, e := strconv.ParseUint(fmt.Sprint(mode), 8, 32)
+			if e != nil {
+				panic(fmt.Sprintf("invalid mode `%v` of goze.server.listeners[%d]", mode, i))
+			}
+			l.Mode = os.FileMode(m)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners
+}
@@ -9,6 +9,7 @@ package bootstrap
 import (
 	"github.com/azzill/goze/common"
 	"github.com/azzill/goze/server"
+	"gopkg.in/yaml.v2"
 	"testing"
 )
 
@@ -32,3 +33,32 @@ func (c *Controller) Mapping(s *server.RestServer) {
 func TestStartGozeApplication(t *testing.T) {
 	StartGozeApplication(&Service{}, &Controller{})
 }
+
+func TestListenerConfigs(t *testing.T) {
+	var v interface{}
+	e := yaml.Unmarshal([]byte(`
+- {network: unix, address: /run/goze.sock, mode: 0660}
+- {network: systemd, address: web, tls: true}
+- {address: "127.0.0.1:9090", route-set: admin, mode: "600"}
+`), &v)
+	if e != nil {
+		t.Fatal(e)
+	}
+	listeners := listenerConfigs(v)
+	expected := []server.ListenerConfig{
+		{Network: "unix", Address: "/run/goze.sock", Mode: 0660},
+		{Network: "systemd", Address: "web", TLS: true},
+		{Address: "127.0.0.1:9090", RouteSet: "admin", Mode: 0600},
+	}
+	if len(listeners) != len(expected) {
+		t.Fatal("unexpected listeners", listeners)
+	}
+	for i := range expected {
+		if listeners[i] != expected[i] {
+			t.Error("unexpected listener", listeners[i])
+		}
+	}
+	if len(listenerConfigs(nil)) != 0 {
+		t.Error("listeners without config")
+	}
+}
@@ -7,12 +7,18 @@
 package cache
 
 import (
+	"errors"
 	"github.com/garyburd/redigo/redis"
 	"log"
+	"sync"
 	"time"
 )
 
+var ErrNotConfigured = errors.New("redis address is not configured")
+
 type RedisClient struct {
+	// the connection is not safe for concurrent use
+	mu   sync.Mutex
 	conn redis.Conn
 }
 
@@ -33,6 +39,35 @@ func NewRedisClient(network string, address string, password string, writeTimeou
 	return client
 }
 
+// close the connection, called when the application shuts down
+func (c *RedisClient) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// send a command and wait for its reply
+func (c *RedisClient) Do(command string, args ...interface{}) (interface{}, error) {
+	var reply interface{}
+	e := c.WithConn(func(conn redis.Conn) error {
+		var e error
+		reply, e = conn.Do(command, args...)
+		return e
+	})
+	return reply, e
+}
+
+// run f with the connection held, so that commands like WATCH, MULTI and EXEC are not interleaved with others
+func (c *RedisClient) WithConn(f func(conn redis.Conn) error) error {
+	if c.conn == nil {
+		return ErrNotConfigured
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return f(c.conn)
+}
+
 func (c *RedisClient) OpsValueSet(key string, value interface{}, ttl time.Duration) bool {
 	panic("not implemented")
 }
@@ -0,0 +1,306 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package codec
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// no codec is registered for the Content-Type of the request, responded as 415
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// no codec is acceptable by the Accept header of the request, responded as 406
+	ErrNotAcceptable = errors.New("not acceptable")
+)
+
+const (
+	MediaTypeJSON      = "application/json"
+	MediaTypeXML       = "application/xml"
+	MediaTypeForm      = "application/x-www-form-urlencoded"
+	MediaTypeMultipart = "multipart/form-data"
+	MediaTypeMsgPack   = "application/msgpack"
+)
+
+// Codec decodes request bodies and encodes responses of a media type
+type Codec interface {
+	// eg: application/json
+	MediaType() string
+	Decode(r *http.Request, dst interface{}) error
+	// Content-Type is set to the media type before Encode is called
+	Encode(wr http.ResponseWriter, v interface{}) error
+}
+
+// Registry holds codecs by media type, the first registered one is the default
+type Registry struct {
+	sync.RWMutex
+	codecs  []Codec
+	aliases map[string]Codec
+}
+
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{aliases: map[string]Codec{}}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+// registry with JSON (default), XML, form-urlencoded, multipart and MessagePack codecs
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry(JSON{}, XML{}, Form{}, Multipart{}, MsgPack{})
+	r.Alias("text/xml", XML{})
+	r.Alias("application/x-msgpack", MsgPack{})
+	return r
+}
+
+// register a codec, the codec of the same media type is replaced
+func (r *Registry) Register(c Codec) {
+	r.Lock()
+	defer r.Unlock()
+	for i, registered := range r.codecs {
+		if strings.EqualFold(registered.MediaType(), c.MediaType()) {
+			r.codecs[i] = c
+			return
+		}
+	}
+	r.codecs = append(r.codecs, c)
+}
+
+// accept another media type for decoding with the codec
+func (r *Registry) Alias(mediaType string, c Codec) {
+	r.Lock()
+	r.aliases[strings.ToLower(mediaType)] = c
+	r.Unlock()
+}
+
+// codec for the Content-Type of a request, parameters such as charset are ignored,
+// the default codec is used if the Content-Type is empty
+func (r *Registry) Decoder(contentType string) (Codec, error) {
+	r.RLock()
+	defer r.RUnlock()
+	if strings.TrimSpace(contentType) == "" {
+		if len(r.codecs) == 0 {
+			return nil, ErrUnsupportedMediaType
+		}
+		return r.codecs[0], nil
+	}
+	mediaType, _, e := mime.ParseMediaType(contentType)
+	if e != nil {
+		return nil, ErrUnsupportedMediaType
+	}
+	for _, c := range r.codecs {
+		if strings.EqualFold(c.MediaType(), mediaType) {
+			return c, nil
+		}
+	}
+	if c := r.aliases[mediaType]; c != nil {
+		return c, nil
+	}
+	return nil, ErrUnsupportedMediaType
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+	order     int
+}
+
+// codec preferred by the Accept header, wildcards like */* and application/* are supported,
+// the default codec is used if the header is empty
+func (r *Registry) Negotiate(accept string) (Codec, error) {
+	r.RLock()
+	defer r.RUnlock()
+	codecs := r.acceptable(accept)
+	if len(codecs) == 0 {
+		return nil, ErrNotAcceptable
+	}
+	return codecs[0], nil
+}
+
+// the codecs acceptable by the Accept header in the order of preference. Another codec than the default
+// one is only preferred if the client prefers it the most, so that the application/xml;q=0.9 of browsers,
+// a fallback next to text/html and */*, does not turn the responses into XML
+func (r *Registry) acceptable(accept string) []Codec {
+	if len(r.codecs) == 0 {
+		return nil
+	}
+	if strings.TrimSpace(accept) == "" {
+		return r.codecs[:1]
+	}
+
+	var ranges []acceptRange
+	top := 0.0
+	for i, part := range strings.Split(accept, ",") {
+		mediaType, params, e := mime.ParseMediaType(strings.TrimSpace(part))
+		if e != nil {
+			continue
+		}
+		q := 1.0
+		if v, has := params["q"]; has {
+			if q, e = strconv.ParseFloat(v, 64); e != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q, order: i})
+		if q > top {
+			top = q
+		}
+	}
+	// higher quality first, then the more specific one, then the order in the header
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+
+	var codecs []Codec
+	firstQ := 0.0
+	add := func(c Codec, q float64) {
+		for _, added := range codecs {
+			if added == c {
+				return
+			}
+		}
+		if len(codecs) == 0 {
+			firstQ = q
+		}
+		codecs = append(codecs, c)
+	}
+	for _, ar := range ranges {
+		if ar.q <= 0 {
+			continue
+		}
+		for _, c := range r.codecs {
+			if matchMediaRange(ar.mediaType, c.MediaType()) && !excluded(ranges, c.MediaType()) {
+				add(c, ar.q)
+			}
+		}
+		if c := r.aliases[ar.mediaType]; c != nil {
+			add(c, ar.q)
+		}
+	}
+
+	if len(codecs) > 1 && firstQ < top && codecs[0] != r.codecs[0] {
+		for i, c := range codecs {
+			if c == r.codecs[0] {
+				copy(codecs[1:i+1], codecs[:i])
+				codecs[0] = c
+				break
+			}
+		}
+	}
+	return codecs
+}
+
+func matchMediaRange(mediaRange string, mediaType string) bool {
+	if mediaRange == "*/*" {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(strings.ToLower(mediaType), strings.TrimSuffix(mediaRange, "*"))
+	}
+	return strings.EqualFold(mediaRange, mediaType)
+}
+
+// media type explicitly refused by q=0
+func excluded(ranges []acceptRange, mediaType string) bool {
+	for _, ar := range ranges {
+		if ar.q <= 0 && strings.EqualFold(ar.mediaType, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// encode v with the codec negotiated by the Accept header of r, the next acceptable codec is tried
+// if v cannot be encoded by one before anything is written, eg: a map by XML
+func (r *Registry) Encode(wr http.ResponseWriter, req *http.Request, v interface{}) error {
+	r.RLock()
+	codecs := r.acceptable(req.Header.Get("Accept"))
+	r.RUnlock()
+	if len(codecs) == 0 {
+		return ErrNotAcceptable
+	}
+
+	var err error
+	for _, c := range codecs {
+		wr.Header().Set("Content-Type", c.MediaType())
+		w := &writeTracker{ResponseWriter: wr}
+		e := c.Encode(w, v)
+		if e == nil || w.written {
+			return e
+		}
+		if err == nil {
+			err = e
+		}
+	}
+	wr.Header().Del("Content-Type")
+	return err
+}
+
+// tells whether a codec has written anything before it failed
+type writeTracker struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *writeTracker) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writeTracker) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+type JSON struct{}
+
+func (JSON) MediaType() string {
+	return MediaTypeJSON
+}
+
+func (JSON) Decode(r *http.Request, dst interface{}) error {
+	return json.NewDecoder(r.Body).Decode(dst)
+}
+
+func (JSON) Encode(wr http.ResponseWriter, v interface{}) error {
+	bytes, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	_, e = wr.Write(bytes)
+	return e
+}
+
+type XML struct{}
+
+func (XML) MediaType() string {
+	return MediaTypeXML
+}
+
+func (XML) Decode(r *http.Request, dst interface{}) error {
+	return xml.NewDecoder(r.Body).Decode(dst)
+}
+
+func (XML) Encode(wr http.ResponseWriter, v interface{}) error {
+	bytes, e := xml.Marshal(v)
+	if e != nil {
+		return e
+	}
+	_, e = wr.Write(bytes)
+	return e
+}
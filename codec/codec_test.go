@@ -0,0 +1,183 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	r := NewDefaultRegistry()
+	cases := map[string]string{
+		"":                                    MediaTypeJSON,
+		"*/*":                                 MediaTypeJSON,
+		"application/xml":                     MediaTypeXML,
+		"text/html, application/xml;q=0.9":    MediaTypeXML,
+		"application/*;q=0.5, text/xml":       MediaTypeXML,
+		"application/json;q=0, application/*": MediaTypeXML,
+		"application/xml;q=0.9, */*;q=0.8":    MediaTypeXML,
+		"application/x-msgpack":               MediaTypeMsgPack,
+		"text/html":                           "",
+		// browsers prefer text/html, their application/xml is a fallback next to */*
+		"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8," +
+			"application/signed-exchange;v=b3;q=0.7": MediaTypeJSON,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8": MediaTypeJSON,
+	}
+	for accept, expected := range cases {
+		c, e := r.Negotiate(accept)
+		if expected == "" {
+			if e != ErrNotAcceptable {
+				t.Error(accept, "expected not acceptable but got", c)
+			}
+			continue
+		}
+		if e != nil || c.MediaType() != expected {
+			t.Error(accept, "expected", expected, "but got", c, e)
+		}
+	}
+
+	if c, e := r.Decoder("application/json; charset=utf-8"); e != nil || c.MediaType() != MediaTypeJSON {
+		t.Error("charset of content-type is not ignored", c, e)
+	}
+	if _, e := r.Decoder("text/csv"); e != ErrUnsupportedMediaType {
+		t.Error("expected unsupported media type but got", e)
+	}
+}
+
+type item struct {
+	Name   string            `json:"name" form:"name"`
+	Count  int               `json:"count"`
+	Price  float64           `json:"price"`
+	Tags   []string          `json:"tags"`
+	Attrs  map[string]string `json:"attrs"`
+	Active bool              `json:"active"`
+	Large  int64             `json:"large"`
+	Minus  int               `json:"minus"`
+	Note   *string           `json:"note"`
+}
+
+func TestMsgPack(t *testing.T) {
+	in := item{Name: strings.Repeat("n", 40), Count: 300, Price: 9.5, Tags: []string{"a", "b"},
+		Attrs: map[string]string{"k": "v"}, Active: true, Large: 1 << 40, Minus: -200}
+	b, e := MarshalMsgPack(in)
+	if e != nil {
+		t.Fatal(e)
+	}
+	out := item{}
+	if e := UnmarshalMsgPack(bytes.NewReader(b), &out); e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Error("expected", in, "but got", out)
+	}
+
+	// truncated input
+	if e := UnmarshalMsgPack(bytes.NewReader(b[:len(b)-3]), &out); e == nil {
+		t.Error("truncated msgpack is accepted")
+	}
+
+	// arrays nested too deep are rejected instead of overflowing the stack
+	var v interface{}
+	deep := bytes.Repeat([]byte{0x91}, 20<<20)
+	if e := UnmarshalMsgPack(bytes.NewReader(deep), &v); !errors.Is(e, errMsgPackFormat) {
+		t.Error("deeply nested msgpack is accepted", e)
+	}
+	nested := append(bytes.Repeat([]byte{0x81, 0xa1, 'k'}, 100), 0xc0)
+	if e := UnmarshalMsgPack(bytes.NewReader(nested), &v); e != nil {
+		t.Error("nested msgpack is rejected", e)
+	}
+}
+
+func TestForm(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=goze&count=3&tags=a&tags=b&active=true"))
+	r.Header.Set("Content-Type", MediaTypeForm)
+	out := item{}
+	if e := (Form{}).Decode(r, &out); e != nil {
+		t.Fatal(e)
+	}
+	if out.Name != "goze" || out.Count != 3 || len(out.Tags) != 2 || !out.Active {
+		t.Error("unexpected form", out)
+	}
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	_ = w.WriteField("name", "goze")
+	fw, _ := w.CreateFormFile("file", "a.txt")
+	_, _ = fw.Write([]byte("content"))
+	_ = w.Close()
+	r = httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	upload := struct {
+		Name string                `form:"name"`
+		File *multipart.FileHeader `form:"file"`
+	}{}
+	if e := (Multipart{}).Decode(r, &upload); e != nil {
+		t.Fatal(e)
+	}
+	if upload.Name != "goze" || upload.File == nil || upload.File.Filename != "a.txt" {
+		t.Error("unexpected multipart form", upload)
+	}
+}
+
+func multipartRequest(fields map[string]string, files map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for k, v := range fields {
+		_ = w.WriteField(k, v)
+	}
+	for name, content := range files {
+		fw, _ := w.CreateFormFile("file", name)
+		_, _ = fw.Write([]byte(content))
+	}
+	_ = w.Close()
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func TestMultipartLimits(t *testing.T) {
+	// files beyond MaxMemory are stored in temporary files
+	m := Multipart{MaxMemory: 1, MaxFileSize: 1024, MaxFiles: 2}
+	r := multipartRequest(map[string]string{"name": "goze"}, map[string]string{"a.txt": strings.Repeat("a", 1024)})
+	form, e := m.ReadForm(r)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer form.RemoveAll()
+	if r.MultipartForm != form || r.FormValue("name") != "goze" || len(form.File["file"]) != 1 {
+		t.Fatal("unexpected form", form)
+	}
+	f, e := form.File["file"][0].Open()
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, _ := ioutil.ReadAll(f)
+	_ = f.Close()
+	if string(b) != strings.Repeat("a", 1024) {
+		t.Error("unexpected content", len(b))
+	}
+	if again, _ := m.ReadForm(r); again != form {
+		t.Error("form is parsed again")
+	}
+
+	r = multipartRequest(nil, map[string]string{"a.txt": strings.Repeat("a", 1025)})
+	if _, e := m.ReadForm(r); !errors.Is(e, ErrFileTooLarge) {
+		t.Error("expected file too large but got", e)
+	}
+	r = multipartRequest(nil, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	if _, e := m.ReadForm(r); !errors.Is(e, ErrTooManyFiles) {
+		t.Error("expected too many files but got", e)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package codec
+
+import (
+	"encoding"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	fileHeaderType      = reflect.TypeOf(&multipart.FileHeader{})
+)
+
+// Form decodes application/x-www-form-urlencoded bodies into structs (fields are named by
+// the `form` tag, the `json` tag or the field name) or maps
+type Form struct{}
+
+func (Form) MediaType() string {
+	return MediaTypeForm
+}
+
+func (Form) Decode(r *http.Request, dst interface{}) error {
+	if e := r.ParseForm(); e != nil {
+		return e
+	}
+	return decodeValues(r.PostForm, nil, dst)
+}
+
+func (Form) Encode(wr http.ResponseWriter, v interface{}) error {
+	values, e := encodeValues(v)
+	if e != nil {
+		return e
+	}
+	_, e = wr.Write([]byte(values.Encode()))
+	return e
+}
+
+func decodeValues(values url.Values, files map[string][]*multipart.FileHeader, dst interface{}) error {
+	switch d := dst.(type) {
+	case *url.Values:
+		*d = values
+		return nil
+	case *map[string][]string:
+		*d = values
+		return nil
+	case *map[string]string:
+		*d = make(map[string]string, len(values))
+		for k := range values {
+			(*d)[k] = values.Get(k)
+		}
+		return nil
+	case *map[string]interface{}:
+		*d = make(map[string]interface{}, len(values))
+		for k, v := range values {
+			if len(v) == 1 {
+				(*d)[k] = v[0]
+			} else {
+				(*d)[k] = v
+			}
+		}
+		return nil
+	}
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form can not be decoded into %T", dst)
+	}
+	return decodeStruct(values, files, v.Elem())
+}
+
+func decodeStruct(values url.Values, files map[string][]*multipart.FileHeader, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue //unexported
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if e := decodeStruct(values, files, v.Field(i)); e != nil {
+				return e
+			}
+			continue
+		}
+
+		name := formName(field)
+		if name == "" {
+			continue
+		}
+
+		switch field.Type {
+		case fileHeaderType:
+			if fhs := files[name]; len(fhs) > 0 {
+				v.Field(i).Set(reflect.ValueOf(fhs[0]))
+			}
+			continue
+		case reflect.SliceOf(fileHeaderType):
+			if fhs := files[name]; len(fhs) > 0 {
+				v.Field(i).Set(reflect.ValueOf(fhs))
+			}
+			continue
+		}
+
+		if vs := values[name]; len(vs) > 0 {
+			if e := SetValues(v.Field(i), vs); e != nil {
+				return fmt.Errorf("invalid field `%s`: %v", name, e)
+			}
+		}
+	}
+	return nil
+}
+
+// name of a field in forms, empty if it is ignored
+func formName(field reflect.StructField) string {
+	for _, key := range []string{"form", "json"} {
+		if tag, has := field.Tag.Lookup(key); has {
+			name := strings.Split(tag, ",")[0]
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return field.Name
+}
+
+func encodeValues(v interface{}) (url.Values, error) {
+	switch m := v.(type) {
+	case url.Values:
+		return m, nil
+	case map[string][]string:
+		return m, nil
+	case map[string]string:
+		values := url.Values{}
+		for k, s := range m {
+			values.Set(k, s)
+		}
+		return values, nil
+	case map[string]interface{}:
+		values := url.Values{}
+		for k, s := range m {
+			values.Set(k, fmt.Sprint(s))
+		}
+		return values, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T can not be encoded as form", v)
+	}
+	values := url.Values{}
+	encodeStruct(rv, values)
+	return values, nil
+}
+
+func encodeStruct(v reflect.Value, values url.Values) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			encodeStruct(v.Field(i), values)
+			continue
+		}
+		name := formName(field)
+		if name == "" {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+		if f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < f.Len(); j++ {
+				values.Add(name, fmt.Sprint(f.Index(j).Interface()))
+			}
+			continue
+		}
+		values.Add(name, fmt.Sprint(f.Interface()))
+	}
+}
+
+// SetValues assigns string values (of a form, a query string or headers) to a field,
+// slices take all of them and other types take the first one
+func SetValues(f reflect.Value, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	if f.Kind() == reflect.Slice && !f.Type().Implements(textUnmarshalerType) && f.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(f.Type(), len(values), len(values))
+		for i, value := range values {
+			if e := setValue(s.Index(i), value); e != nil {
+				return e
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+	return setValue(f, values[0])
+}
+
+func setValue(f reflect.Value, value string) error {
+	if f.Kind() == reflect.Ptr {
+		v := reflect.New(f.Type().Elem())
+		if e := setValue(v.Elem(), value); e != nil {
+			return e
+		}
+		f.Set(v)
+		return nil
+	}
+
+	if f.CanAddr() && f.Addr().Type().Implements(textUnmarshalerType) {
+		return f.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	if f.Type() == durationType {
+		d, e := time.ParseDuration(value)
+		if e != nil {
+			return e
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(value)
+		if e != nil {
+			return e
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, e := strconv.ParseInt(value, 10, f.Type().Bits())
+		if e != nil {
+			return e
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, e := strconv.ParseUint(value, 10, f.Type().Bits())
+		if e != nil {
+			return e
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, e := strconv.ParseFloat(value, f.Type().Bits())
+		if e != nil {
+			return e
+		}
+		f.SetFloat(n)
+	case reflect.Interface:
+		if f.NumMethod() != 0 {
+			return fmt.Errorf("unsupported type %v", f.Type())
+		}
+		f.Set(reflect.ValueOf(value))
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %v", f.Type())
+		}
+		f.SetBytes([]byte(value))
+	default:
+		return fmt.Errorf("unsupported type %v", f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// MsgPack encodes and decodes MessagePack, values go through their json representation
+// so that the `json` tags and json.Marshaler are honoured the same way as JSON does,
+// binary and extension types of MessagePack are decoded as base64 strings
+type MsgPack struct{}
+
+func (MsgPack) MediaType() string {
+	return MediaTypeMsgPack
+}
+
+func (MsgPack) Decode(r *http.Request, dst interface{}) error {
+	return UnmarshalMsgPack(r.Body, dst)
+}
+
+func (MsgPack) Encode(wr http.ResponseWriter, v interface{}) error {
+	b, e := MarshalMsgPack(v)
+	if e != nil {
+		return e
+	}
+	_, e = wr.Write(b)
+	return e
+}
+
+func MarshalMsgPack(v interface{}) ([]byte, error) {
+	j, e := json.Marshal(v)
+	if e != nil {
+		return nil, e
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var generic interface{}
+	if e := d.Decode(&generic); e != nil {
+		return nil, e
+	}
+	buf := &bytes.Buffer{}
+	if e := writeMsgPack(buf, generic); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalMsgPack(r io.Reader, dst interface{}) error {
+	generic, e := readMsgPack(bufio.NewReader(r), 0)
+	if e != nil {
+		return e
+	}
+	j, e := json.Marshal(generic)
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(j, dst)
+}
+
+func writeMsgPack(w *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		w.WriteByte(0xc0)
+	case bool:
+		if t {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, e := strconv.ParseInt(string(t), 10, 64); e == nil {
+			writeInt(w, i)
+		} else if u, e := strconv.ParseUint(string(t), 10, 64); e == nil {
+			w.WriteByte(0xcf)
+			_ = binary.Write(w, binary.BigEndian, u)
+		} else if f, e := t.Float64(); e == nil {
+			w.WriteByte(0xcb)
+			_ = binary.Write(w, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return e
+		}
+	case string:
+		writeLength(w, len(t), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		w.WriteString(t)
+	case []interface{}:
+		writeLength(w, len(t), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range t {
+			if e := writeMsgPack(w, item); e != nil {
+				return e
+			}
+		}
+	case map[string]interface{}:
+		writeLength(w, len(t), 0x80, 15, 0, 0xde, 0xdf)
+		for k, item := range t {
+			if e := writeMsgPack(w, k); e != nil {
+				return e
+			}
+			if e := writeMsgPack(w, item); e != nil {
+				return e
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+func writeInt(w *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		w.WriteByte(byte(i))
+	case i >= -32 && i < 0:
+		w.WriteByte(byte(0xe0 | (i + 32)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		w.WriteByte(0xd0)
+		w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		w.WriteByte(0xd1)
+		_ = binary.Write(w, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		w.WriteByte(0xd2)
+		_ = binary.Write(w, binary.BigEndian, int32(i))
+	default:
+		w.WriteByte(0xd3)
+		_ = binary.Write(w, binary.BigEndian, i)
+	}
+}
+
+// write the header of a str, array or map, code8 is 0 if there is no 8-bit variant
+func writeLength(w *bytes.Buffer, n int, fix byte, fixMax int, code8 byte, code16 byte, code32 byte) {
+	switch {
+	case n <= fixMax:
+		w.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		w.WriteByte(code8)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(code16)
+		_ = binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(code32)
+		_ = binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+var errMsgPackFormat = errors.New("msgpack: invalid format")
+
+// max nesting of arrays and maps, as encoding/json, so that a small body of nested
+// headers cannot overflow the stack
+const msgPackMaxDepth = 10000
+
+func readMsgPack(r *bufio.Reader, depth int) (interface{}, error) {
+	c, e := r.ReadByte()
+	if e != nil {
+		return nil, e
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return readString(r, int(c&0x1f))
+	case c&0xf0 == 0x90:
+		return readArray(r, int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return readMap(r, int(c&0x0f), depth)
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, e := readUint(r, 1<<(c-0xcc))
+		return n, e
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, e := readUint(r, size)
+		if e != nil {
+			return nil, e
+		}
+		// sign extension
+		shift := uint(64 - size*8)
+		return int64(n<<shift) >> shift, nil
+	case 0xca:
+		n, e := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), e
+	case 0xcb:
+		n, e := readUint(r, 8)
+		return math.Float64frombits(n), e
+	case 0xd9, 0xda, 0xdb:
+		n, e := readUint(r, 1<<(c-0xd9))
+		if e != nil {
+			return nil, e
+		}
+		return readString(r, int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, e := readUint(r, 1<<(c-0xc4))
+		if e != nil {
+			return nil, e
+		}
+		b, e := readBytes(r, int(n))
+		return base64.StdEncoding.EncodeToString(b), e
+	case 0xdc, 0xdd:
+		n, e := readUint(r, 2<<(c-0xdc))
+		if e != nil {
+			return nil, e
+		}
+		return readArray(r, int(n), depth)
+	case 0xde, 0xdf:
+		n, e := readUint(r, 2<<(c-0xde))
+		if e != nil {
+			return nil, e
+		}
+		return readMap(r, int(n), depth)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		// fixext, one byte of type then 1, 2, 4, 8 or 16 bytes of data
+		b, e := readBytes(r, 1+1<<(c-0xd4))
+		if e != nil {
+			return nil, e
+		}
+		return base64.StdEncoding.EncodeToString(b[1:]), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, e := readUint(r, 1<<(c-0xc7))
+		if e != nil {
+			return nil, e
+		}
+		b, e := readBytes(r, int(n)+1)
+		if e != nil {
+			return nil, e
+		}
+		return base64.StdEncoding.EncodeToString(b[1:]), nil
+	}
+	return nil, errMsgPackFormat
+}
+
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	b, e := readBytes(r, size)
+	if e != nil {
+		return 0, e
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// the buffer grows as data arrives instead of trusting the length in the header
+func readBytes(r *bufio.Reader, n int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if _, e := io.CopyN(buf, r, int64(n)); e != nil {
+		if e == io.EOF {
+			e = io.ErrUnexpectedEOF
+		}
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+func capacity(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+func readString(r *bufio.Reader, n int) (string, error) {
+	b, e := readBytes(r, n)
+	return string(b), e
+}
+
+func readArray(r *bufio.Reader, n int, depth int) ([]interface{}, error) {
+	if depth >= msgPackMaxDepth {
+		return nil, errMsgPackFormat
+	}
+	a := make([]interface{}, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		v, e := readMsgPack(r, depth+1)
+		if e != nil {
+			return nil, e
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func readMap(r *bufio.Reader, n int, depth int) (map[string]interface{}, error) {
+	if depth >= msgPackMaxDepth {
+		return nil, errMsgPackFormat
+	}
+	m := make(map[string]interface{}, capacity(n))
+	for i := 0; i < n; i++ {
+		k, e := readMsgPack(r, depth+1)
+		if e != nil {
+			return nil, e
+		}
+		v, e := readMsgPack(r, depth+1)
+		if e != nil {
+			return nil, e
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+)
+
+// max memory used by multipart forms, larger files are stored in temporary files
+const defaultMultipartMemory = 32 << 20
+
+var (
+	// a file of a multipart form exceeds Multipart.MaxFileSize, responded as 413
+	ErrFileTooLarge = errors.New("file too large")
+	// a multipart form has more files than Multipart.MaxFiles, responded as 413
+	ErrTooManyFiles = errors.New("too many files")
+)
+
+// FormReader is implemented by the codecs parsing multipart forms
+type FormReader interface {
+	ReadForm(r *http.Request) (*multipart.Form, error)
+}
+
+// Multipart decodes multipart/form-data bodies like Form, the files are assigned to
+// the fields of type *multipart.FileHeader or []*multipart.FileHeader
+type Multipart struct {
+	// max memory of the form, 32MB if zero
+	MaxMemory int64
+	// max size of every file, unlimited if zero
+	MaxFileSize int64
+	// max number of files, unlimited if zero
+	MaxFiles int
+}
+
+func (Multipart) MediaType() string {
+	return MediaTypeMultipart
+}
+
+func (m Multipart) Decode(r *http.Request, dst interface{}) error {
+	form, e := m.ReadForm(r)
+	if e != nil {
+		return e
+	}
+	return decodeValues(form.Value, form.File, dst)
+}
+
+// ReadForm parses the form of r once and sets r.MultipartForm, the parts are streamed and the files
+// beyond MaxMemory are stored in temporary files, which are removed by http.Server after the request
+func (m Multipart) ReadForm(r *http.Request) (*multipart.Form, error) {
+	if r.MultipartForm != nil {
+		return r.MultipartForm, nil
+	}
+	reader, e := r.MultipartReader()
+	if e != nil {
+		return nil, e
+	}
+	maxMemory := m.MaxMemory
+	if maxMemory <= 0 {
+		maxMemory = defaultMultipartMemory
+	}
+
+	var form *multipart.Form
+	if m.MaxFileSize <= 0 && m.MaxFiles <= 0 {
+		form, e = reader.ReadForm(maxMemory)
+	} else {
+		form, e = m.readLimited(reader, maxMemory)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	// the values are also in r.Form and r.PostForm like http.Request.ParseMultipartForm does
+	if e := r.ParseForm(); e != nil {
+		_ = form.RemoveAll()
+		return nil, e
+	}
+	for k, v := range form.Value {
+		r.Form[k] = append(r.Form[k], v...)
+		r.PostForm[k] = append(r.PostForm[k], v...)
+	}
+	r.MultipartForm = form
+	return form, nil
+}
+
+// the parts are copied through a pipe, so that a file is refused as soon as it exceeds the limit
+// while multipart.Reader.ReadForm still does the buffering and the temporary files
+func (m Multipart) readLimited(reader *multipart.Reader, maxMemory int64) (*multipart.Form, error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		_ = pw.CloseWithError(m.copyParts(reader, w))
+	}()
+	form, e := multipart.NewReader(pr, w.Boundary()).ReadForm(maxMemory)
+	// stop copying if the form is refused by ReadForm
+	_ = pr.Close()
+	return form, e
+}
+
+func (m Multipart) copyParts(reader *multipart.Reader, w *multipart.Writer) error {
+	files := 0
+	for {
+		part, e := reader.NextPart()
+		if e == io.EOF {
+			return w.Close()
+		}
+		if e != nil {
+			return e
+		}
+		dst, e := w.CreatePart(part.Header)
+		if e != nil {
+			return e
+		}
+		if part.FileName() == "" {
+			if _, e := io.Copy(dst, part); e != nil {
+				return e
+			}
+			continue
+		}
+
+		files++
+		if m.MaxFiles > 0 && files > m.MaxFiles {
+			return fmt.Errorf("%w: more than %d", ErrTooManyFiles, m.MaxFiles)
+		}
+		if m.MaxFileSize <= 0 {
+			if _, e := io.Copy(dst, part); e != nil {
+				return e
+			}
+			continue
+		}
+		n, e := io.Copy(dst, io.LimitReader(part, m.MaxFileSize+1))
+		if e != nil {
+			return e
+		}
+		if n > m.MaxFileSize {
+			return fmt.Errorf("%w: %s exceeds %d bytes", ErrFileTooLarge, part.FileName(), m.MaxFileSize)
+		}
+	}
+}
+
+func (Multipart) Encode(wr http.ResponseWriter, v interface{}) error {
+	values, e := encodeValues(v)
+	if e != nil {
+		return e
+	}
+	w := multipart.NewWriter(wr)
+	wr.Header().Set("Content-Type", w.FormDataContentType())
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, value := range values[k] {
+			if e := w.WriteField(k, value); e != nil {
+				return e
+			}
+		}
+	}
+	return w.Close()
+}
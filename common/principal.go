@@ -0,0 +1,29 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package common
+
+// Principal is the authenticated client of a request
+type Principal struct {
+	Subject string
+	Roles   []string
+	// the scheme it is authenticated by, eg: bearer, api-key or basic
+	Scheme string
+	// claims of the token or attributes of the api key
+	Claims map[string]interface{}
+}
+
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
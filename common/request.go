@@ -8,13 +8,13 @@ package common
 
 import (
 	sql2 "database/sql"
-	"encoding/json"
+	"github.com/azzill/goze/codec"
 	"github.com/azzill/goze/log"
 	"github.com/azzill/goze/sql"
-	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"net/http"
-	"strings"
+	"strconv"
 )
 
 type RequestCtx struct {
@@ -24,9 +24,16 @@ type RequestCtx struct {
 	Form           *multipart.Form
 	Tx             *sql.Tx
 	ResponseWriter http.ResponseWriter
+	// codecs decoding the body, the default registry is used if nil
+	Codecs *codec.Registry
+	// metadata of the matched route
+	Metadata map[string]interface{}
+	// the authenticated client, nil if anonymous
+	Principal *Principal
 	//=========
-	sql     *sql.SQL
-	txBegan bool
+	sql        *sql.SQL
+	txBegan    bool
+	onComplete []func()
 }
 
 func NewRequestCtx(queryString map[string][]string, pathVariable map[string]string, request *http.Request, form *multipart.Form, responseWriter http.ResponseWriter, sqls *sql.SQL) *RequestCtx {
@@ -48,6 +55,8 @@ func NewRequestCtx(queryString map[string][]string, pathVariable map[string]stri
 
 var logger = log.NewLogger("RestServer")
 
+var defaultCodecs = codec.NewDefaultRegistry()
+
 func (c *RequestCtx) BeginTx() {
 	//if tx began, commit it
 	if c.txBegan {
@@ -60,27 +69,110 @@ func (c *RequestCtx) BeginTx() {
 	c.txBegan = true
 
 }
-func (c *RequestCtx) ParseBody(dst interface{}) error {
-	ct := c.Request.Header.Get("Content-Type")
-	if !strings.EqualFold(ct, "application/json") {
-		logger.Info("content-type: '%s' is not supported yet\n", ct)
-		return nil
+
+// hook called once the response is written, eg: to close a writer swapped into ResponseWriter.
+// Hooks run in the reverse order they are added
+func (c *RequestCtx) OnComplete(hook func()) {
+	c.onComplete = append(c.onComplete, hook)
+}
+
+// called by the server after the response wrappers
+func (c *RequestCtx) Complete() {
+	for i := len(c.onComplete) - 1; i >= 0; i-- {
+		c.onComplete[i]()
 	}
+	c.onComplete = nil
+}
 
-	//TODO support url-encoded
+// decode the body with the codec registered for its Content-Type,
+// codec.ErrUnsupportedMediaType is returned if there is none
+func (c *RequestCtx) ParseBody(dst interface{}) error {
+	decoder, e := c.codecs().Decoder(c.Request.Header.Get("Content-Type"))
+	if e != nil {
+		logger.Info("content-type:", c.Request.Header.Get("Content-Type"), "is not supported")
+		return e
+	}
 
-	bytes, e := ioutil.ReadAll(c.Request.Body) //Note that ReadAll is not safe for oom attack
 	defer func() {
 		_ = c.Request.Body.Close()
 	}()
 
-	if e != nil {
+	if e := decoder.Decode(c.Request, dst); e != nil {
 		return e
 	}
+	if c.Request.MultipartForm != nil {
+		c.Form = c.Request.MultipartForm
+	}
+	return nil
+}
 
-	e = json.Unmarshal(bytes, dst)
+// the form of the request parsed on the first call and kept in Form, multipart forms are parsed by
+// the multipart codec of Codecs (with its memory and file limits), other bodies as urlencoded forms
+func (c *RequestCtx) ParseForm() (*multipart.Form, error) {
+	if c.Form != nil {
+		return c.Form, nil
+	}
+	r := c.Request
+	if r.MultipartForm != nil {
+		c.Form = r.MultipartForm
+		return c.Form, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != codec.MediaTypeMultipart {
+		if e := r.ParseForm(); e != nil {
+			return nil, e
+		}
+		c.Form = &multipart.Form{Value: r.PostForm, File: map[string][]*multipart.FileHeader{}}
+		return c.Form, nil
+	}
+	decoder, e := c.codecs().Decoder(mediaType)
 	if e != nil {
-		return e
+		return nil, e
 	}
-	return nil
+	reader, ok := decoder.(codec.FormReader)
+	if !ok {
+		return nil, codec.ErrUnsupportedMediaType
+	}
+	form, e := reader.ReadForm(r)
+	if e != nil {
+		return nil, e
+	}
+	c.Form = form
+	return form, nil
+}
+
+func (c *RequestCtx) codecs() *codec.Registry {
+	if c.Codecs == nil {
+		return defaultCodecs
+	}
+	return c.Codecs
+}
+
+// typed accessors of path variables, zero value is returned if the variable is
+// missing or malformed, constrain the placeholder like {id:int} to make sure it is valid
+
+func (c *RequestCtx) PathInt(name string) int {
+	v, _ := strconv.Atoi(c.PathVariable[name])
+	return v
+}
+
+func (c *RequestCtx) PathInt64(name string) int64 {
+	v, _ := strconv.ParseInt(c.PathVariable[name], 10, 64)
+	return v
+}
+
+func (c *RequestCtx) PathUint64(name string) uint64 {
+	v, _ := strconv.ParseUint(c.PathVariable[name], 10, 64)
+	return v
+}
+
+func (c *RequestCtx) PathFloat(name string) float64 {
+	v, _ := strconv.ParseFloat(c.PathVariable[name], 64)
+	return v
+}
+
+func (c *RequestCtx) PathBool(name string) bool {
+	v, _ := strconv.ParseBool(c.PathVariable[name])
+	return v
 }
@@ -7,19 +7,26 @@
 package context
 
 import (
+	gocontext "context"
 	"fmt"
 	"github.com/azzill/goze/config"
+	"github.com/azzill/goze/discover"
+	"github.com/azzill/goze/lifecycle"
 	"github.com/azzill/goze/log"
 	"github.com/azzill/goze/midware"
 	"github.com/azzill/goze/server"
 	"github.com/azzill/goze/sql"
+	"io"
 	"reflect"
+	"sort"
 )
 
 // The context of goze application instance
 type ApplicationContext struct {
 	Components    map[string]interface{}
 	Configuration *config.CommonConfiguration
+	// names of the components injected into each component
+	dependencies map[string][]string
 }
 
 type Controller interface {
@@ -60,6 +67,9 @@ func (c *ApplicationContext) With(component interface{}) *ApplicationContext {
 	case midware.Interceptor:
 		c.Components["RestServer"].(*server.RestServer).AddInterceptor(component.(midware.Interceptor))
 
+	case midware.Around:
+		c.Components["RestServer"].(*server.RestServer).AddAround(component.(midware.Around))
+
 	case *sql.SQL:
 		c.Components["RestServer"].(*server.RestServer).WithSQL(component.(*sql.SQL))
 	}
@@ -87,6 +97,7 @@ func (c *ApplicationContext) Inject() {
 					// have tag with inject
 					if reflect.ValueOf(candi).Type().AssignableTo(cElement.Field(i).Type()) {
 						cElement.Field(i).Set(reflect.ValueOf(candi))
+						c.addDependency(cElement.Type().Name(), reflect.ValueOf(candi).Elem().Type().Name())
 						logger.Info("Component:", cElement.Type().Name(), "Field:",
 							reflect.TypeOf(v).Elem().Field(i).Name, "has been injected with component",
 							reflect.ValueOf(candi).Elem().Type().Name())
@@ -101,3 +112,57 @@ func (c *ApplicationContext) Inject() {
 
 	}
 }
+
+func (c *ApplicationContext) addDependency(name string, dependency string) {
+	if c.dependencies == nil {
+		c.dependencies = map[string][]string{}
+	}
+	c.dependencies[name] = append(c.dependencies[name], dependency)
+}
+
+// register the components that hold resources as shutdown hooks: *discover.InstanceManager is
+// unregistered and components with `Close() error` (eg: *sql.SQL, *cache.RedisClient) are closed.
+// A component is shut down before the components injected into it
+func (c *ApplicationContext) RegisterShutdownHooks(manager *lifecycle.Manager) {
+	for _, name := range c.dependencyOrder() {
+		switch component := c.Components[name].(type) {
+		case *discover.InstanceManager:
+			manager.OnShutdown(name, func(ctx gocontext.Context) error {
+				component.Unregister()
+				return nil
+			})
+		case io.Closer:
+			manager.OnShutdown(name, func(ctx gocontext.Context) error {
+				return component.Close()
+			})
+		}
+	}
+}
+
+// names of the components, each one after its dependencies
+func (c *ApplicationContext) dependencyOrder() []string {
+	names := make([]string, 0, len(c.Components))
+	for name := range c.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	order := make([]string, 0, len(names))
+	visited := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		// marked before visiting the dependencies, so that cycles are broken
+		visited[name] = true
+		for _, dependency := range c.dependencies[name] {
+			visit(dependency)
+		}
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return order
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package context
+
+import (
+	"github.com/azzill/goze/lifecycle"
+	"github.com/azzill/goze/server"
+	"strings"
+	"testing"
+)
+
+var closed []string
+
+type Pool struct{}
+
+func (*Pool) Close() error {
+	closed = append(closed, "Pool")
+	return nil
+}
+
+type Repository struct {
+	Pool *Pool `inject:"true"`
+}
+
+func (*Repository) Close() error {
+	closed = append(closed, "Repository")
+	return nil
+}
+
+type Cache struct{}
+
+func (*Cache) Close() error {
+	closed = append(closed, "Cache")
+	return nil
+}
+
+type Service struct {
+	Repository *Repository `inject:"true"`
+	Cache      *Cache      `inject:"true"`
+}
+
+func (*Service) Close() error {
+	closed = append(closed, "Service")
+	return nil
+}
+
+func TestRegisterShutdownHooks(t *testing.T) {
+	ctx := &ApplicationContext{Components: map[string]interface{}{}}
+	ctx.With(server.NewRestServer(":8080", nil))
+	ctx.With(&Service{}).With(&Pool{}).With(&Cache{}).With(&Repository{})
+	ctx.Inject()
+
+	m := lifecycle.NewManager(0)
+	ctx.RegisterShutdownHooks(m)
+	m.Shutdown()
+
+	// dependents are closed before their dependencies
+	position := map[string]int{}
+	for i, name := range closed {
+		position[name] = i
+	}
+	if len(closed) != 4 || position["Service"] > position["Repository"] || position["Service"] > position["Cache"] ||
+		position["Repository"] > position["Pool"] {
+		t.Error("unexpected order", strings.Join(closed, ","))
+	}
+}
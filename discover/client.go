@@ -94,18 +94,21 @@ func (InstanceManager *InstanceManager) Register(service *MicroService, remoteAd
 
 //unregister service when exit
 func (InstanceManager *InstanceManager) Unregister() {
-	heartbeatTicker.Stop()
-	heartbeatTicker = nil
+	if heartbeatTicker != nil {
+		heartbeatTicker.Stop()
+		heartbeatTicker = nil
+	}
+	current, addr := InstanceManager.current, InstanceManager.addr
 	InstanceManager.addr = ""
 	InstanceManager.current = nil
 	InstanceManager.info = ServiceInstances{}
 
-	if InstanceManager.current == nil {
+	if current == nil {
 		return
 	}
 
-	_, e := util.RestRequest(server.Delete, InstanceManager.addr,
-		&ServiceInfo{Guid: InstanceManager.current.InstanceId, ServiceName: InstanceManager.current.ServiceName}, nil)
+	_, e := util.RestRequest(server.Delete, addr,
+		&ServiceInfo{Guid: current.InstanceId, ServiceName: current.ServiceName}, nil)
 
 	if e != nil {
 		logger.Error(e)
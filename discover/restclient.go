@@ -8,6 +8,7 @@ package discover
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -30,6 +31,6 @@ func NewRestClient(timeout time.Duration, service *MicroService) *RestClient {
 	return &RestClient{
 		client:  http.Client{Timeout: timeout},
 		Timeout: timeout,
-		baseUrl: service.Address + ":" + string(service.Port),
+		baseUrl: service.Address + ":" + strconv.FormatUint(uint64(service.Port), 10),
 	}
 }
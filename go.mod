@@ -1,6 +1,6 @@
 module github.com/azzill/goze
 
-go 1.12
+go 1.20
 
 require github.com/garyburd/redigo v1.6.0
 
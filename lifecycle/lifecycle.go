@@ -0,0 +1,211 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/azzill/goze/log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultDrainTimeout = 30 * time.Second
+	DefaultHookTimeout  = 10 * time.Second
+)
+
+var logger = log.NewLogger("Lifecycle")
+
+// Drainer stops accepting new requests and waits for the active ones, eg: *http.Server
+type Drainer interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Hook releases a resource when the application shuts down, the context is done when it times out
+type Hook func(ctx context.Context) error
+
+type hook struct {
+	name string
+	fn   Hook
+}
+
+// Restarter starts a new process of the application taking over its listeners, the current
+// process is shut down once it returns nil
+type Restarter func() error
+
+// Manager shuts the application down on SIGINT or SIGTERM: the servers are drained for up to
+// DrainTimeout, then the hooks run in reverse order of registration, so that a component
+// registered after its dependencies is shut down before them
+type Manager struct {
+	DrainTimeout time.Duration
+	HookTimeout  time.Duration
+
+	mu       sync.Mutex
+	servers  []Drainer
+	hooks    []hook
+	restart  Restarter
+	once     sync.Once
+	stopping chan struct{}
+	done     chan struct{}
+}
+
+func NewManager(drainTimeout time.Duration) *Manager {
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	return &Manager{DrainTimeout: drainTimeout, HookTimeout: DefaultHookTimeout,
+		stopping: make(chan struct{}), done: make(chan struct{})}
+}
+
+// servers are drained concurrently before any hook runs
+func (m *Manager) AddServer(server Drainer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, server)
+}
+
+func (m *Manager) OnShutdown(name string, fn Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// restart on SIGHUP or SIGUSR2 while waiting, the process keeps running if the restart fails
+func (m *Manager) OnRestart(restart Restarter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restart = restart
+}
+
+// closed when the shutdown starts
+func (m *Manager) Stopping() <-chan struct{} {
+	return m.stopping
+}
+
+// closed when the shutdown completes
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+// block until one of the signals (SIGINT and SIGTERM by default) is received or Shutdown is called,
+// then return after the shutdown completes. The application is restarted on SIGHUP or SIGUSR2 if a
+// Restarter is set
+func (m *Manager) Wait(signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	m.mu.Lock()
+	restart := m.restart
+	m.mu.Unlock()
+	if restart != nil {
+		signals = append(signals, restartSignals...)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case s := <-sig:
+			if restart != nil && isRestartSignal(s) {
+				logger.Info("Received", s.String(), "- restarting")
+				if e := restart(); e != nil {
+					logger.Error("Restart failed -", e.Error())
+					continue
+				}
+				logger.Info("New process is ready - shutting down")
+			} else {
+				logger.Info("Received", s.String(), "- shutting down")
+			}
+			m.Shutdown()
+			return
+		case <-m.stopping:
+			<-m.done
+			return
+		}
+	}
+}
+
+func isRestartSignal(s os.Signal) bool {
+	for _, r := range restartSignals {
+		if s == r {
+			return true
+		}
+	}
+	return false
+}
+
+// drain the servers and run the hooks, it is done once and the other callers wait for it
+func (m *Manager) Shutdown() {
+	m.once.Do(func() {
+		close(m.stopping)
+		m.mu.Lock()
+		servers := append([]Drainer(nil), m.servers...)
+		hooks := append([]hook(nil), m.hooks...)
+		m.mu.Unlock()
+
+		m.drain(servers)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			m.run(hooks[i])
+		}
+		logger.Info("Shutdown completed")
+		close(m.done)
+	})
+	<-m.done
+}
+
+func (m *Manager) drain(servers []Drainer) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.DrainTimeout)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server Drainer) {
+			defer wg.Done()
+			if e := server.Shutdown(ctx); e != nil {
+				logger.Warn("Drain timed out, closing active connections -", e.Error())
+				// close the connections still active, eg: *http.Server
+				if closer, ok := server.(interface{ Close() error }); ok {
+					_ = closer.Close()
+				}
+			}
+		}(server)
+	}
+	wg.Wait()
+}
+
+// a failed, panicking or hanging hook does not stop the others
+func (m *Manager) run(h hook) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.HookTimeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				result <- fmt.Errorf("panic: %v", e)
+			}
+		}()
+		result <- h.fn(ctx)
+	}()
+
+	select {
+	case e := <-result:
+		if e != nil {
+			logger.Error("Shutdown hook", h.name, "failed -", e.Error())
+			return
+		}
+		logger.Info("Shutdown hook", h.name, "completed")
+	case <-ctx.Done():
+		logger.Error("Shutdown hook", h.name, "timed out")
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package lifecycle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type slowServer struct {
+	closed bool
+}
+
+func (s *slowServer) Shutdown(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *slowServer) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestDrainTimeout(t *testing.T) {
+	m := NewManager(20 * time.Millisecond)
+	m.HookTimeout = 20 * time.Millisecond
+	server := &slowServer{}
+	m.AddServer(server)
+	hooked := false
+	m.OnShutdown("after", func(ctx context.Context) error {
+		hooked = true
+		return nil
+	})
+	m.OnShutdown("hanging", func(ctx context.Context) error {
+		select {}
+	})
+
+	start := time.Now()
+	m.Shutdown()
+	if !server.closed || !hooked {
+		t.Error("server is not closed after the drain timeout", server.closed, hooked)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("shutdown is blocked by a hanging hook")
+	}
+}
@@ -0,0 +1,134 @@
+//go:build !windows
+
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// the signals are sent to the test process itself
+func TestShutdown(t *testing.T) {
+	listener, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = wr.Write([]byte("drained"))
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	m := NewManager(time.Second)
+	m.AddServer(server)
+	mu := sync.Mutex{}
+	var order []string
+	record := func(name string, e error) Hook {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return e
+		}
+	}
+	m.OnShutdown("sql", record("sql", nil))
+	m.OnShutdown("failing", record("failing", errors.New("failed")))
+	m.OnShutdown("panicking", func(ctx context.Context) error {
+		panic("boom")
+	})
+	m.OnShutdown("service", record("service", nil))
+
+	// the request in flight is completed
+	body := make(chan string)
+	go func() {
+		resp, e := http.Get("http://" + listener.Addr().String())
+		if e != nil {
+			body <- e.Error()
+			return
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		body <- string(b)
+	}()
+	<-started
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	}()
+	m.Wait()
+
+	if b := <-body; b != "drained" {
+		t.Error("request is not drained", b)
+	}
+	if strings.Join(order, ",") != "service,failing,sql" {
+		t.Error("unexpected order of hooks", order)
+	}
+	// new requests are refused
+	if _, e := http.Get("http://" + listener.Addr().String()); e == nil {
+		t.Error("server still accepts requests")
+	}
+	select {
+	case <-m.Done():
+	default:
+		t.Error("manager is not done")
+	}
+	// shut down only once
+	m.Shutdown()
+	if len(order) != 3 {
+		t.Error("hooks run again", order)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	m := NewManager(time.Second)
+	attempts := 0
+	m.OnRestart(func() error {
+		attempts++
+		select {
+		case <-m.Stopping():
+			t.Error("shut down by a failed restart")
+		default:
+		}
+		// the first restart fails and the process keeps running
+		if attempts == 1 {
+			return errors.New("not ready")
+		}
+		return nil
+	})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+		time.Sleep(20 * time.Millisecond)
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	}()
+	m.Wait()
+
+	if attempts != 2 {
+		t.Error("unexpected restarts", attempts)
+	}
+	select {
+	case <-m.Done():
+	default:
+		t.Error("manager is not done after the restart")
+	}
+}
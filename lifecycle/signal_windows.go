@@ -0,0 +1,14 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package lifecycle
+
+import (
+	"os"
+)
+
+// listeners can not be inherited by a new process
+var restartSignals []os.Signal
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"github.com/azzill/goze/common"
+	"sync"
+)
+
+// Next handles the request with the rest of the chain: the inner arounds, the interceptors, the handler
+// and the commit of the sql transaction. The result is not written yet, it goes on to the response wrappers
+type Next func(ctx *common.RequestCtx) interface{}
+
+// Around wraps the handling of a request, it sees the result and the latency of next, may call it
+// several times (eg: retries) or not at all, and returns the value written by the response wrappers.
+// A panic of next goes through the arounds, so they can recover and translate it
+type Around interface {
+	Around(ctx *common.RequestCtx, next Next) interface{}
+}
+
+// eg: midware.AroundFunc(func(ctx *common.RequestCtx, next midware.Next) interface{} {
+//		start := time.Now()
+//		defer func() { fmt.Println(ctx.Request.URL.Path, time.Since(start)) }()
+//		return next(ctx)
+//	})
+
+type AroundFunc func(ctx *common.RequestCtx, next Next) interface{}
+
+func (f AroundFunc) Around(ctx *common.RequestCtx, next Next) interface{} {
+	return f(ctx, next)
+}
+
+// AroundChain runs the arounds in the order they are added, the first one is the outermost
+type AroundChain struct {
+	sync.Mutex
+	around []Around
+}
+
+// Preparer is implemented by the arounds checking their config, it is called when they are registered,
+// so that a wrong config fails the startup rather than the requests
+type Preparer interface {
+	Prepare()
+}
+
+// prepare the arounds implementing Preparer
+func Prepare(arounds ...Around) {
+	for _, around := range arounds {
+		if p, ok := around.(Preparer); ok {
+			p.Prepare()
+		}
+	}
+}
+
+func (r *AroundChain) AddAround(around Around) {
+	Prepare(around)
+	r.Lock()
+	r.around = append(r.around, around)
+	r.Unlock()
+}
+
+func (r *AroundChain) Arounds() []Around {
+	r.Lock()
+	defer r.Unlock()
+	return append([]Around{}, r.around...)
+}
+
+// next wrapped by the arounds of the chain, next itself if there is none
+func (r *AroundChain) Wrap(next Next) Next {
+	return Wrap(next, r.around...)
+}
+
+// next wrapped by the arounds, the first one is the outermost
+func Wrap(next Next, arounds ...Around) Next {
+	for i := len(arounds) - 1; i >= 0; i-- {
+		around, inner := arounds[i], next
+		next = func(ctx *common.RequestCtx) interface{} {
+			return around.Around(ctx, inner)
+		}
+	}
+	return next
+}
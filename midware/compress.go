@@ -0,0 +1,323 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/config"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// bodies smaller than it are sent as is if Compression.MinSize is zero
+const DefaultCompressionMinSize = 1024
+
+// media types compressed if Compression.ContentTypes is empty, * matches any part of the type
+var DefaultCompressibleTypes = []string{"text/*", "application/json", "application/*+json", "application/xml",
+	"application/*+xml", "application/javascript", "application/x-www-form-urlencoded", "image/svg+xml"}
+
+// Compression compresses the bodies of the responses with gzip or deflate negotiated from Accept-Encoding.
+// The writer of the request is swapped, so that response wrappers writing to it directly are compressed too.
+// Bodies already encoded, partial content and event streams are sent as is.
+// Register it with ApplicationContext.With(&midware.Compression{}) to load goze.server.compression
+type Compression struct {
+	// min bytes of a body to compress it, a flushed stream is compressed whatever its size
+	MinSize int
+	// gzip.DefaultCompression if zero
+	Level int
+	// media types to compress
+	ContentTypes []string
+
+	pools [2]sync.Pool
+}
+
+// load goze.server.compression, the values which are not configured are kept
+func (c *Compression) Config(cfg *config.CommonConfiguration) interface{} {
+	compression := &Compression{MinSize: c.MinSize, Level: c.Level, ContentTypes: c.ContentTypes}
+	if v, ok := cfg.Get("goze.server.compression.min-size").(int); ok {
+		compression.MinSize = v
+	}
+	if v, ok := cfg.Get("goze.server.compression.level").(int); ok {
+		compression.Level = v
+	}
+	if v := cfg.Get("goze.server.compression.content-types"); v != nil {
+		compression.ContentTypes = stringList("goze.server.compression.content-types", v)
+	}
+	compression.Prepare()
+	return compression
+}
+
+// check the level, called when the compression is registered, panics if it is wrong
+func (c *Compression) Prepare() {
+	if _, e := gzip.NewWriterLevel(io.Discard, c.level()); e != nil {
+		panic(fmt.Sprintf("invalid compression level %d", c.Level))
+	}
+}
+
+func (c *Compression) Around(ctx *common.RequestCtx, next Next) interface{} {
+	// the body of an upgraded connection is not http
+	if ctx.Request.Header.Get("Upgrade") != "" {
+		return next(ctx)
+	}
+	ctx.ResponseWriter.Header().Add("Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(ctx.Request.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return next(ctx)
+	}
+	w := &compressWriter{ResponseWriter: ctx.ResponseWriter, compression: c, encoding: encoding, status: http.StatusOK}
+	ctx.ResponseWriter = w
+	ctx.OnComplete(w.close)
+	return next(ctx)
+}
+
+func (c *Compression) level() int {
+	if c.Level == 0 {
+		return gzip.DefaultCompression
+	}
+	return c.Level
+}
+
+func (c *Compression) minSize() int {
+	if c.MinSize <= 0 {
+		return DefaultCompressionMinSize
+	}
+	return c.MinSize
+}
+
+func (c *Compression) compressible(contentType string) bool {
+	mediaType, _, e := mime.ParseMediaType(contentType)
+	if e != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	types := c.ContentTypes
+	if len(types) == 0 {
+		types = DefaultCompressibleTypes
+	}
+	for _, t := range types {
+		if matchMediaType(strings.ToLower(t), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchMediaType(pattern string, mediaType string) bool {
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return pattern == mediaType
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(mediaType) >= len(prefix)+len(suffix) && strings.HasPrefix(mediaType, prefix) &&
+		strings.HasSuffix(mediaType, suffix)
+}
+
+// a writer of the pool of the encoding writing to w, fails if the level is wrong
+func (c *Compression) writer(encoding string, w io.Writer) (io.WriteCloser, error) {
+	if encoding == EncodingGzip {
+		if gz, ok := c.pools[0].Get().(*gzip.Writer); ok {
+			gz.Reset(w)
+			return gz, nil
+		}
+		return gzip.NewWriterLevel(w, c.level())
+	}
+	if z, ok := c.pools[1].Get().(*zlib.Writer); ok {
+		z.Reset(w)
+		return z, nil
+	}
+	return zlib.NewWriterLevel(w, c.level())
+}
+
+func (c *Compression) release(w io.WriteCloser) {
+	switch w := w.(type) {
+	case *gzip.Writer:
+		c.pools[0].Put(w)
+	case *zlib.Writer:
+		c.pools[1].Put(w)
+	}
+}
+
+// the encoding of the highest quality, gzip is preferred on a tie, empty if none is accepted
+func negotiateEncoding(accept string) string {
+	quality := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if v, e := strconv.ParseFloat(param[2:], 64); e == nil {
+					q = v
+				}
+			}
+		}
+		if name != "" {
+			quality[name] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate} {
+		q, has := quality[encoding]
+		if !has {
+			q = quality["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the body until MinSize is reached, a flush or the end of the response,
+// then decides from the status and the headers whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	compression *Compression
+	encoding    string
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	// nil if the body is sent as is
+	writer io.WriteCloser
+	closed bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.decided {
+		return
+	}
+	// informational responses are sent at once
+	if status >= 100 && status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status, w.wroteHeader = status, true
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.closed && w.writer != nil {
+		return 0, errors.New("write after the compressed response is complete")
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.compression.minSize() {
+			return len(b), nil
+		}
+		if e := w.decide(true); e != nil {
+			return 0, e
+		}
+		return len(b), nil
+	}
+	if w.writer != nil {
+		return w.writer.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// compress the body if large enough and the response allows it, then write the header and the buffer
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if large && w.compressible() {
+		// sent as is if the compression is not registered and its level is wrong
+		if writer, e := w.compression.writer(w.encoding, w.ResponseWriter); e != nil {
+			logger.Error("Compression failed -", e.Error())
+		} else {
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			header.Set("Content-Encoding", w.encoding)
+			w.writer = writer
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var e error
+	if w.writer != nil {
+		_, e = w.writer.Write(buf)
+	} else {
+		_, e = w.ResponseWriter.Write(buf)
+	}
+	return e
+}
+
+func (w *compressWriter) compressible() bool {
+	header := w.ResponseWriter.Header()
+	if w.status < 200 || w.status == http.StatusNoContent || w.status == http.StatusNotModified ||
+		w.status == http.StatusPartialContent {
+		return false
+	}
+	// already encoded or a range of the body
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	return w.compression.compressible(header.Get("Content-Type"))
+}
+
+// a flushed body is a stream, it is compressed whatever its size
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		_ = w.decide(true)
+	}
+	if f, ok := w.writer.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	if !w.decided {
+		// nothing is written if the response has no header nor body yet, eg: a panic
+		if !w.wroteHeader && len(w.buf) == 0 {
+			w.decided = true
+			return
+		}
+		_ = w.decide(len(w.buf) >= w.compression.minSize())
+	}
+	if w.writer != nil {
+		_ = w.writer.Close()
+		w.compression.release(w.writer)
+	}
+}
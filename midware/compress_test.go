@@ -0,0 +1,161 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/azzill/goze/common"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compress(c *Compression, acceptEncoding string, handler func(wr http.ResponseWriter)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	wr := httptest.NewRecorder()
+	ctx := common.NewRequestCtx(nil, nil, r, nil, wr, nil)
+	c.Around(ctx, func(ctx *common.RequestCtx) interface{} {
+		handler(ctx.ResponseWriter)
+		return nil
+	})
+	ctx.Complete()
+	return wr
+}
+
+func decompress(t *testing.T, wr *httptest.ResponseRecorder) string {
+	var reader io.Reader = wr.Body
+	var e error
+	switch wr.Header().Get("Content-Encoding") {
+	case EncodingGzip:
+		reader, e = gzip.NewReader(wr.Body)
+	case EncodingDeflate:
+		reader, e = zlib.NewReader(wr.Body)
+	}
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, e := io.ReadAll(reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return string(b)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"gzip, deflate, br":        EncodingGzip,
+		"deflate":                  EncodingDeflate,
+		"gzip;q=0.5, deflate":      EncodingDeflate,
+		"*":                        EncodingGzip,
+		"*;q=0.5, gzip;q=0":        EncodingDeflate,
+		"identity, gzip;q=0":       "",
+		"GZIP;q=0.8, deflate;q=.2": EncodingGzip,
+	}
+	for accept, expected := range cases {
+		if encoding := negotiateEncoding(accept); encoding != expected {
+			t.Error(accept, "expected", expected, "but got", encoding)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	c := &Compression{MinSize: 64}
+	large := strings.Repeat(`{"name":"goze"}`, 20)
+	json := func(body string) func(wr http.ResponseWriter) {
+		return func(wr http.ResponseWriter) {
+			wr.Header().Set("Content-Type", "application/json")
+			wr.Header().Set("Content-Length", "1")
+			wr.WriteHeader(http.StatusCreated)
+			_, _ = wr.Write([]byte(body))
+		}
+	}
+
+	wr := compress(c, "gzip", json(large))
+	if wr.Code != http.StatusCreated || wr.Header().Get("Content-Encoding") != EncodingGzip || wr.Header().Get("Content-Length") != "" ||
+		wr.Header().Get("Vary") != "Accept-Encoding" || decompress(t, wr) != large {
+		t.Error("body is not compressed", wr.Code, wr.Header())
+	}
+	if wr := compress(c, "deflate", json(large)); wr.Header().Get("Content-Encoding") != EncodingDeflate || decompress(t, wr) != large {
+		t.Error("body is not deflated", wr.Header())
+	}
+	// a pooled writer is reused
+	if wr := compress(c, "gzip", json(large+large)); decompress(t, wr) != large+large {
+		t.Error("unexpected body of the pooled writer")
+	}
+
+	sentAsIs := []struct {
+		name    string
+		accept  string
+		handler func(wr http.ResponseWriter)
+	}{
+		{"not accepted", "identity", json(large)},
+		{"small", "gzip", json(`{"name":"goze"}`)},
+		{"image", "gzip", func(wr http.ResponseWriter) {
+			wr.Header().Set("Content-Type", "image/png")
+			_, _ = wr.Write([]byte(large))
+		}},
+		{"already encoded", "gzip", func(wr http.ResponseWriter) {
+			wr.Header().Set("Content-Type", "application/json")
+			wr.Header().Set("Content-Encoding", "br")
+			_, _ = wr.Write([]byte(large))
+		}},
+		{"event stream", "gzip", func(wr http.ResponseWriter) {
+			wr.Header().Set("Content-Type", "text/event-stream")
+			_, _ = wr.Write([]byte(large))
+			wr.(http.Flusher).Flush()
+		}},
+	}
+	for _, cs := range sentAsIs {
+		wr := compress(c, cs.accept, cs.handler)
+		if encoding := wr.Header().Get("Content-Encoding"); encoding == EncodingGzip || !strings.HasPrefix(wr.Body.String(), `{"name"`) {
+			t.Error(cs.name, "is compressed", wr.Header())
+		}
+	}
+	if wr := compress(c, "gzip", func(wr http.ResponseWriter) { wr.WriteHeader(http.StatusNoContent) }); wr.Code != http.StatusNoContent ||
+		wr.Header().Get("Content-Encoding") != "" {
+		t.Error("unexpected empty response", wr.Code, wr.Header())
+	}
+
+	// a flushed stream is compressed at once whatever its size
+	wr = compress(c, "gzip", func(wr http.ResponseWriter) {
+		wr.Header().Set("Content-Type", "text/plain")
+		_, _ = wr.Write([]byte("chunk"))
+		wr.(http.Flusher).Flush()
+		if !wr.(*compressWriter).decided {
+			t.Error("stream is buffered")
+		}
+		_, _ = wr.Write([]byte("chunk"))
+	})
+	if wr.Header().Get("Content-Encoding") != EncodingGzip || decompress(t, wr) != "chunkchunk" || !wr.Flushed {
+		t.Error("stream is not compressed", wr.Header())
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	// a wrong level of a compression which is not registered is sent as is
+	body := strings.Repeat("a", 2*DefaultCompressionMinSize)
+	wr := compress(&Compression{Level: 42}, "gzip", func(wr http.ResponseWriter) {
+		wr.Header().Set("Content-Type", "text/plain")
+		_, _ = wr.Write([]byte(body))
+	})
+	if wr.Header().Get("Content-Encoding") != "" || wr.Body.String() != body {
+		t.Error("unexpected response", wr.Header())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong level is registered")
+		}
+	}()
+	var chain AroundChain
+	chain.AddAround(&Compression{Level: 42})
+}
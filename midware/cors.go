@@ -0,0 +1,199 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"fmt"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/config"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// methods allowed by a preflight if CORS.AllowMethods is empty
+var DefaultCORSMethods = []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE"}
+
+// CORS answers the preflight requests of the mapped routes and adds the CORS headers to the others.
+// It is an Around, so that preflights are answered before interceptors (eg: authentication) run,
+// register it with ApplicationContext.With(&midware.CORS{}) to load goze.server.cors
+type CORS struct {
+	// exact origins (https://example.com), wildcard subdomains (https://*.example.com) or * for any
+	AllowOrigins []string
+	// regular expressions matching the whole origin
+	AllowOriginPatterns []string
+	// DefaultCORSMethods if empty
+	AllowMethods []string
+	// the headers requested by the preflight are allowed if empty or *
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	// how long the result of a preflight may be cached, not sent if zero
+	MaxAge time.Duration
+
+	once     sync.Once
+	patterns []*regexp.Regexp
+}
+
+// load goze.server.cors, the values which are not configured are kept
+func (c *CORS) Config(cfg *config.CommonConfiguration) interface{} {
+	cors := &CORS{AllowOrigins: c.AllowOrigins, AllowOriginPatterns: c.AllowOriginPatterns, AllowMethods: c.AllowMethods,
+		AllowHeaders: c.AllowHeaders, ExposeHeaders: c.ExposeHeaders, AllowCredentials: c.AllowCredentials, MaxAge: c.MaxAge}
+	if v := cfg.Get("goze.server.cors.allow-origins"); v != nil {
+		cors.AllowOrigins = stringList("goze.server.cors.allow-origins", v)
+	}
+	if v := cfg.Get("goze.server.cors.allow-origin-patterns"); v != nil {
+		cors.AllowOriginPatterns = stringList("goze.server.cors.allow-origin-patterns", v)
+	}
+	if v := cfg.Get("goze.server.cors.allow-methods"); v != nil {
+		cors.AllowMethods = stringList("goze.server.cors.allow-methods", v)
+	}
+	if v := cfg.Get("goze.server.cors.allow-headers"); v != nil {
+		cors.AllowHeaders = stringList("goze.server.cors.allow-headers", v)
+	}
+	if v := cfg.Get("goze.server.cors.expose-headers"); v != nil {
+		cors.ExposeHeaders = stringList("goze.server.cors.expose-headers", v)
+	}
+	if v, ok := cfg.Get("goze.server.cors.allow-credentials").(bool); ok {
+		cors.AllowCredentials = v
+	}
+	if v, ok := cfg.Get("goze.server.cors.max-age").(int); ok {
+		cors.MaxAge = time.Duration(v) * time.Second
+	}
+	cors.Prepare()
+	return cors
+}
+
+// a list or a comma separated string
+func stringList(key string, v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		list := strings.Split(v, ",")
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+		return list
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	default:
+		panic(fmt.Sprintf("%s must be a list", key))
+	}
+}
+
+// check the config and compile the patterns, called when the CORS is registered, panics if the config is wrong.
+// Credentials are never allowed for any origin, it would let every site act as the user
+func (c *CORS) Prepare() {
+	if c.AllowCredentials && c.anyOrigin() {
+		panic("CORS cannot allow credentials for any origin `*`, list the origins instead")
+	}
+	c.once.Do(func() {
+		for _, p := range c.AllowOriginPatterns {
+			c.patterns = append(c.patterns, regexp.MustCompile("^(?:"+p+")$"))
+		}
+	})
+}
+
+func (c *CORS) Around(ctx *common.RequestCtx, next Next) interface{} {
+	r, header := ctx.Request, ctx.ResponseWriter.Header()
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if origin == "" {
+		return next(ctx)
+	}
+	// the response depends on the origin, it must not be cached for others
+	header.Add("Vary", "Origin")
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if !c.allowOrigin(origin) {
+		if preflight {
+			// no CORS headers, the browser refuses the request
+			ctx.ResponseWriter.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		return next(ctx)
+	}
+
+	if !c.anyOrigin() {
+		header.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", "*")
+	}
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(c.ExposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposeHeaders, ", "))
+		}
+		return next(ctx)
+	}
+
+	methods := c.AllowMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	if contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			if len(c.AllowHeaders) == 0 || contains(c.AllowHeaders, "*") {
+				header.Set("Access-Control-Allow-Headers", requested)
+			} else {
+				header.Set("Access-Control-Allow-Headers", strings.Join(c.AllowHeaders, ", "))
+			}
+		}
+		if c.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+	}
+	ctx.ResponseWriter.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (c *CORS) anyOrigin() bool {
+	return contains(c.AllowOrigins, "*")
+}
+
+func (c *CORS) allowOrigin(origin string) bool {
+	c.Prepare()
+	for _, allowed := range c.AllowOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// https://*.example.com matches the subdomains of example.com only
+		if i := strings.Index(allowed, "*."); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+				return true
+			}
+		}
+	}
+	for _, p := range c.patterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func cors(c *CORS, method string, header map[string]string) (*httptest.ResponseRecorder, bool) {
+	r := httptest.NewRequest(method, "/users", nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	wr := httptest.NewRecorder()
+	called := false
+	c.Around(common.NewRequestCtx(nil, nil, r, nil, wr, nil), func(ctx *common.RequestCtx) interface{} {
+		called = true
+		return nil
+	})
+	return wr, called
+}
+
+func TestCORS(t *testing.T) {
+	c := &CORS{AllowOrigins: []string{"https://example.com", "https://*.goze.io"},
+		AllowOriginPatterns: []string{`http://localhost:\d+`}, AllowHeaders: []string{"Authorization"},
+		ExposeHeaders: []string{"X-Total"}, AllowCredentials: true, MaxAge: time.Hour}
+
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://example.com", true},
+		{"https://api.goze.io", true},
+		{"https://goze.io", false},
+		{"https://goze.io.evil.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3000.evil.com", false},
+		{"https://evil.com", false},
+	}
+	for _, cs := range cases {
+		wr, called := cors(c, http.MethodGet, map[string]string{"Origin": cs.origin})
+		if allowed := wr.Header().Get("Access-Control-Allow-Origin") == cs.origin; allowed != cs.allowed || !called {
+			t.Error(cs.origin, "expected", cs.allowed, "but got", wr.Header())
+		}
+	}
+	wr, _ := cors(c, http.MethodGet, map[string]string{"Origin": "https://example.com"})
+	if wr.Header().Get("Access-Control-Allow-Credentials") != "true" || wr.Header().Get("Access-Control-Expose-Headers") != "X-Total" ||
+		wr.Header().Get("Vary") != "Origin" {
+		t.Error("unexpected headers", wr.Header())
+	}
+
+	// preflight is answered without the handler
+	wr, called := cors(c, http.MethodOptions, map[string]string{"Origin": "https://example.com",
+		"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "authorization"})
+	if called || wr.Code != http.StatusNoContent || wr.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, PUT, PATCH, POST, DELETE" ||
+		wr.Header().Get("Access-Control-Allow-Headers") != "Authorization" || wr.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Error("unexpected preflight", called, wr.Code, wr.Header())
+	}
+	wr, _ = cors(c, http.MethodOptions, map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "TRACE"})
+	if wr.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Error("method is allowed", wr.Header())
+	}
+	wr, _ = cors(c, http.MethodOptions, map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "GET"})
+	if wr.Code != http.StatusNoContent || wr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("origin is allowed", wr.Header())
+	}
+	// plain OPTIONS goes to the handler
+	if _, called := cors(c, http.MethodOptions, map[string]string{"Origin": "https://example.com"}); !called {
+		t.Error("OPTIONS is answered as a preflight")
+	}
+
+	// any origin without credentials
+	wr, _ = cors(&CORS{AllowOrigins: []string{"*"}}, http.MethodOptions, map[string]string{"Origin": "https://evil.com",
+		"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Custom"})
+	if wr.Header().Get("Access-Control-Allow-Origin") != "*" || wr.Header().Get("Access-Control-Allow-Headers") != "X-Custom" ||
+		wr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("unexpected preflight", wr.Header())
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("credentials are allowed for any origin")
+		}
+	}()
+	var chain AroundChain
+	chain.AddAround(&CORS{AllowOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestCORSConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	yaml := `
+goze:
+  server:
+    cors:
+      allow-origins: [https://example.com]
+      allow-methods: GET, POST
+      allow-credentials: true
+      max-age: 600
+`
+	if e := os.WriteFile(path, []byte(yaml), 0600); e != nil {
+		t.Fatal(e)
+	}
+	c := (&CORS{ExposeHeaders: []string{"X-Total"}}).Config(config.NewCommonConfiguration(path)).(*CORS)
+	if len(c.AllowOrigins) != 1 || c.AllowOrigins[0] != "https://example.com" || len(c.AllowMethods) != 2 || c.AllowMethods[1] != "POST" ||
+		!c.AllowCredentials || c.MaxAge != 10*time.Minute || len(c.ExposeHeaders) != 1 {
+		t.Error("unexpected config", c)
+	}
+}
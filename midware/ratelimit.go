@@ -0,0 +1,202 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RateAlgorithm string
+
+const (
+	// Limit requests in a burst, refilled evenly over Window
+	TokenBucket RateAlgorithm = "token-bucket"
+	// Limit requests in any Window, weighted from the counts of the current and the previous window
+	SlidingWindow RateAlgorithm = "sliding-window"
+)
+
+// returned by RateLimiter when the key exceeds its limit, it is responded as 429 Too Many Requests
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// the clock of the limiters, replaced by the tests
+var now = time.Now
+
+type RateLimit struct {
+	Algorithm RateAlgorithm
+	Limit     int
+	Window    time.Duration
+}
+
+type RateDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// until the limit is fully available again
+	Reset time.Duration
+	// until the next request is allowed, zero if allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore counts the requests of the keys, the store of a cluster is shared by its instances
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateDecision, error)
+}
+
+// RateKey identifies the client a request is counted for
+type RateKey func(ctx *common.RequestCtx) string
+
+// the address of the peer, use HeaderKey("X-Real-IP") behind a trusted proxy
+func ClientIP(ctx *common.RequestCtx) string {
+	host, _, e := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if e != nil {
+		return ctx.Request.RemoteAddr
+	}
+	return host
+}
+
+// the value of the header, the requests without it are counted by ClientIP
+func HeaderKey(name string) RateKey {
+	return func(ctx *common.RequestCtx) string {
+		if v := ctx.Request.Header.Get(name); v != "" {
+			return "header:" + v
+		}
+		return "ip:" + ClientIP(ctx)
+	}
+}
+
+// the value of the path variable, the requests without it are counted by ClientIP
+func PathVariableKey(name string) RateKey {
+	return func(ctx *common.RequestCtx) string {
+		if v := ctx.PathVariable[name]; v != "" {
+			return "path:" + v
+		}
+		return "ip:" + ClientIP(ctx)
+	}
+}
+
+// RateLimiter is an Around limiting the requests of each key, add it to the server, a group or a route.
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset are sent with the responses, requests over
+// the limit get ErrRateLimited with Retry-After. The requests are allowed if the store fails
+type RateLimiter struct {
+	// TokenBucket if empty
+	Algorithm RateAlgorithm
+	Limit     int
+	Window    time.Duration
+	// ClientIP if nil
+	Key RateKey
+	// a memory store of the limiter if nil
+	Store RateLimitStore
+	// prefix of the keys, so that the limiters sharing a store count apart, eg: the name of the route
+	Name string
+
+	once sync.Once
+}
+
+// a limiter of limit requests per window, the other fields may be set before it is registered
+func NewRateLimiter(algorithm RateAlgorithm, limit int, window time.Duration) *RateLimiter {
+	l := &RateLimiter{Algorithm: algorithm, Limit: limit, Window: window}
+	l.Prepare()
+	return l
+}
+
+// check the config and fill the defaults, called when the limiter is registered, panics if the config is wrong
+func (l *RateLimiter) Prepare() {
+	if l.Limit <= 0 || l.Window <= 0 {
+		panic("limit and window of the rate limiter must be positive")
+	}
+	if l.Algorithm != "" && l.Algorithm != TokenBucket && l.Algorithm != SlidingWindow {
+		panic(fmt.Sprintf("unknown rate limit algorithm `%s`", l.Algorithm))
+	}
+	l.once.Do(func() {
+		if l.Algorithm == "" {
+			l.Algorithm = TokenBucket
+		}
+		if l.Key == nil {
+			l.Key = ClientIP
+		}
+		if l.Store == nil {
+			l.Store = NewMemoryRateStore()
+		}
+	})
+}
+
+func (l *RateLimiter) Around(ctx *common.RequestCtx, next Next) interface{} {
+	l.Prepare()
+
+	decision, e := l.Store.Take(l.Name+":"+l.Key(ctx), RateLimit{Algorithm: l.Algorithm, Limit: l.Limit, Window: l.Window})
+	if e != nil {
+		logger.Error("Rate limit store failed -", e.Error())
+		return next(ctx)
+	}
+	header := ctx.ResponseWriter.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(seconds(decision.Reset)))
+	if !decision.Allowed {
+		header.Set("Retry-After", strconv.Itoa(seconds(decision.RetryAfter)))
+		return ErrRateLimited
+	}
+	return next(ctx)
+}
+
+// whole seconds rounded up, so that clients do not retry too early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// generic cell rate algorithm: the bucket is the theoretical arrival time (tat) of the next request,
+// which moves by Window/Limit for each request and may run ahead of now by at most Window
+func takeToken(tat time.Time, t time.Time, limit RateLimit) (RateDecision, time.Time) {
+	interval := limit.Window / time.Duration(limit.Limit)
+	if tat.Before(t) {
+		tat = t
+	}
+	next := tat.Add(interval)
+	decision := RateDecision{Limit: limit.Limit}
+	if allowAt := next.Add(-limit.Window); t.Before(allowAt) {
+		decision.RetryAfter = allowAt.Sub(t)
+		decision.Reset = tat.Sub(t)
+		return decision, tat
+	}
+	decision.Allowed = true
+	decision.Reset = next.Sub(t)
+	decision.Remaining = int((limit.Window - decision.Reset) / interval)
+	return decision, next
+}
+
+// the count of the previous window is weighted by the part of it still in the sliding window
+func slideWindow(previous int, current int, t time.Time, limit RateLimit) RateDecision {
+	elapsed := time.Duration(t.UnixNano() % int64(limit.Window))
+	weight := float64(limit.Window-elapsed) / float64(limit.Window)
+	count := int(math.Floor(float64(previous)*weight)) + current
+	decision := RateDecision{Limit: limit.Limit, Reset: limit.Window - elapsed}
+	if count > limit.Limit {
+		// the weighted previous count falls by one request every Window/previous
+		if previous > 0 {
+			decision.RetryAfter = time.Duration(float64(count-limit.Limit) / float64(previous) * float64(limit.Window))
+		}
+		if decision.RetryAfter <= 0 || decision.RetryAfter > decision.Reset {
+			decision.RetryAfter = decision.Reset
+		}
+		return decision
+	}
+	decision.Allowed = true
+	decision.Remaining = limit.Limit - count
+	return decision
+}
+
+// the index of the window t falls in
+func windowOf(t time.Time, window time.Duration) int64 {
+	return t.UnixNano() / int64(window)
+}
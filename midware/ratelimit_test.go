@@ -0,0 +1,359 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/cache"
+	"github.com/azzill/goze/common"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a local stand-in of redis speaking RESP, with the commands used by RedisRateStore
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+	expires  map[string]time.Time
+	// incremented by every write, WATCH compares them on EXEC
+	versions map[string]int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	r := &fakeRedis{listener: l, values: map[string]string{}, expires: map[string]time.Time{}, versions: map[string]int{}}
+	go func() {
+		for {
+			conn, e := l.Accept()
+			if e != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	return r
+}
+
+func (r *fakeRedis) client() *cache.RedisClient {
+	return cache.NewRedisClient("tcp", r.listener.Addr().String(), "", time.Second, time.Second, time.Second, 0)
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	watched := map[string]int{}
+	var queue [][]string
+	multi := false
+	for {
+		args, e := readCommand(reader)
+		if e != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "MULTI":
+			multi, queue = true, nil
+			writeReply(writer, "OK")
+		case name == "EXEC":
+			r.mu.Lock()
+			changed := false
+			for key, version := range watched {
+				changed = changed || r.versions[key] != version
+			}
+			replies := make([]interface{}, 0, len(queue))
+			for _, command := range queue {
+				if !changed {
+					replies = append(replies, r.do(command))
+				}
+			}
+			r.mu.Unlock()
+			multi, watched = false, map[string]int{}
+			if changed {
+				writeReply(writer, nil)
+			} else {
+				writeReply(writer, replies)
+			}
+		case name == "WATCH":
+			r.mu.Lock()
+			for _, key := range args[1:] {
+				watched[key] = r.versions[key]
+			}
+			r.mu.Unlock()
+			writeReply(writer, "OK")
+		case name == "UNWATCH":
+			watched = map[string]int{}
+			writeReply(writer, "OK")
+		case multi:
+			queue = append(queue, args)
+			writeReply(writer, "QUEUED")
+		default:
+			r.mu.Lock()
+			writeReply(writer, r.do(args))
+			r.mu.Unlock()
+		}
+		if e := writer.Flush(); e != nil {
+			return
+		}
+	}
+}
+
+func (r *fakeRedis) do(args []string) interface{} {
+	key := args[1]
+	if expires, has := r.expires[key]; has && !time.Now().Before(expires) {
+		delete(r.values, key)
+		delete(r.expires, key)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if v, has := r.values[key]; has {
+			return []byte(v)
+		}
+		return nil
+	case "SET":
+		r.values[key] = args[2]
+		delete(r.expires, key)
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			r.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		r.versions[key]++
+		return "OK"
+	case "INCR", "DECR":
+		n, _ := strconv.Atoi(r.values[key])
+		if strings.ToUpper(args[0]) == "INCR" {
+			n++
+		} else {
+			n--
+		}
+		r.values[key] = strconv.Itoa(n)
+		r.versions[key]++
+		return int64(n)
+	case "PEXPIRE":
+		ms, _ := strconv.Atoi(args[2])
+		r.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return int64(1)
+	default:
+		return errors.New("ERR unknown command " + args[0])
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, e := reader.ReadString('\n')
+	if e != nil {
+		return nil, e
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, count)
+	for i := range args {
+		line, e := reader.ReadString('\n')
+		if e != nil {
+			return nil, e
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		b := make([]byte, size+2)
+		if _, e := io.ReadFull(reader, b); e != nil {
+			return nil, e
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		_, _ = w.WriteString("$-1\r\n")
+	case string:
+		_, _ = w.WriteString("+" + v + "\r\n")
+	case error:
+		_, _ = w.WriteString("-" + v.Error() + "\r\n")
+	case int64:
+		_, _ = fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		_, _ = fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+// set the clock of the limiters
+func setClock(t *testing.T, clock *time.Time) {
+	now = func() time.Time {
+		return *clock
+	}
+	t.Cleanup(func() {
+		now = time.Now
+	})
+}
+
+func TestRateLimitStores(t *testing.T) {
+	redis := newFakeRedis(t)
+	stores := map[string]func() RateLimitStore{
+		"memory": func() RateLimitStore { return NewMemoryRateStore() },
+		"redis":  func() RateLimitStore { return NewRedisRateStore(redis.client()) },
+	}
+	// aligned to the windows, so that the sliding window starts with an empty previous window
+	clock := time.Unix(1000, 0)
+	setClock(t, &clock)
+
+	for name, store := range stores {
+		s := store()
+		bucket := RateLimit{Algorithm: TokenBucket, Limit: 3, Window: 3 * time.Second}
+		key := name + ":bucket"
+		for i := 0; i < 3; i++ {
+			if d, e := s.Take(key, bucket); e != nil || !d.Allowed || d.Remaining != 2-i {
+				t.Fatal(name, "request", i, "is not allowed", d, e)
+			}
+		}
+		d, e := s.Take(key, bucket)
+		if e != nil || d.Allowed || d.RetryAfter != time.Second || d.Reset != 3*time.Second {
+			t.Error(name, "burst is not limited", d, e)
+		}
+		// a token is refilled every second
+		clock = clock.Add(time.Second)
+		if d, _ := s.Take(key, bucket); !d.Allowed || d.Remaining != 0 {
+			t.Error(name, "token is not refilled", d)
+		}
+		if d, _ := s.Take(key, bucket); d.Allowed {
+			t.Error(name, "refilled token is taken twice", d)
+		}
+
+		window := RateLimit{Algorithm: SlidingWindow, Limit: 4, Window: 10 * time.Second}
+		key = name + ":window"
+		for i := 0; i < 4; i++ {
+			if d, e := s.Take(key, window); e != nil || !d.Allowed || d.Remaining != 3-i {
+				t.Fatal(name, "request", i, "is not allowed", d, e)
+			}
+		}
+		if d, _ := s.Take(key, window); d.Allowed {
+			t.Error(name, "window is not limited", d)
+		}
+		// half of the previous window weighs in
+		clock = clock.Add(14 * time.Second)
+		allowed := 0
+		for i := 0; i < 4; i++ {
+			if d, _ := s.Take(key, window); d.Allowed {
+				allowed++
+			} else if d.RetryAfter <= 0 || d.RetryAfter > 5*time.Second {
+				t.Error(name, "unexpected retry after", d)
+			}
+		}
+		if allowed != 2 {
+			t.Error(name, "expected 2 requests allowed in the sliding window but got", allowed)
+		}
+		clock = clock.Add(-15 * time.Second)
+	}
+
+	// the instances of a cluster share the limit
+	first, second := NewRedisRateStore(redis.client()), NewRedisRateStore(redis.client())
+	bucket := RateLimit{Algorithm: TokenBucket, Limit: 10, Window: time.Minute}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(s RateLimitStore) {
+			defer wg.Done()
+			d, e := s.Take("shared", bucket)
+			if e != nil {
+				t.Error(e)
+			}
+			mu.Lock()
+			if d.Allowed {
+				allowed++
+			}
+			mu.Unlock()
+		}([]RateLimitStore{first, second}[i%2])
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Error("expected 10 requests allowed across the instances but got", allowed)
+	}
+
+	if _, e := NewRedisRateStore(&cache.RedisClient{}).Take("key", bucket); !errors.Is(e, cache.ErrNotConfigured) {
+		t.Error("unexpected error", e)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	setClock(t, &clock)
+	limiter := &RateLimiter{Limit: 2, Window: time.Minute, Key: HeaderKey("X-Api-Key")}
+	take := func(key string) (*httptest.ResponseRecorder, interface{}) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Api-Key", key)
+		wr := httptest.NewRecorder()
+		ret := limiter.Around(common.NewRequestCtx(nil, nil, r, nil, wr, nil), func(ctx *common.RequestCtx) interface{} {
+			return "ok"
+		})
+		return wr, ret
+	}
+	take("a")
+	wr, ret := take("a")
+	if ret != "ok" || wr.Header().Get("RateLimit-Limit") != "2" || wr.Header().Get("RateLimit-Remaining") != "0" ||
+		wr.Header().Get("RateLimit-Reset") != "60" {
+		t.Error("unexpected response", ret, wr.Header())
+	}
+	wr, ret = take("a")
+	if ret != ErrRateLimited || wr.Header().Get("Retry-After") != "30" {
+		t.Error("request is not limited", ret, wr.Header())
+	}
+	if _, ret := take("b"); ret != "ok" {
+		t.Error("keys are not counted apart", ret)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/users/7", nil)
+	ctx := common.NewRequestCtx(nil, map[string]string{"id": "7"}, r, nil, httptest.NewRecorder(), nil)
+	if ClientIP(ctx) != "192.0.2.1" || PathVariableKey("id")(ctx) != "path:7" {
+		t.Error("unexpected keys", ClientIP(ctx), PathVariableKey("id")(ctx))
+	}
+	// clients without the key are counted apart by their address
+	if k := HeaderKey("X-Api-Key")(ctx); k != "ip:192.0.2.1" {
+		t.Error("unexpected key of a request without the header", k)
+	}
+	r.RemoteAddr = "192.0.2.2:1234"
+	if k := PathVariableKey("name")(ctx); k != "ip:192.0.2.2" {
+		t.Error("unexpected key of a request without the path variable", k)
+	}
+
+	// wrong configs fail when the limiter is created or registered, not on the requests
+	for _, l := range []*RateLimiter{{Limit: 0, Window: time.Minute}, {Limit: 1}, {Limit: 1, Window: time.Minute, Algorithm: "leaky"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("wrong config is accepted", l.Limit, l.Window, l.Algorithm)
+				}
+			}()
+			(&AroundChain{}).AddAround(l)
+		}()
+	}
+	if l := NewRateLimiter(SlidingWindow, 1, time.Second); l.Key == nil || l.Store == nil {
+		t.Error("defaults are not filled", l)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package midware
+
+import (
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/cache"
+	"github.com/garyburd/redigo/redis"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// max tries of a token bucket update when the key is changed concurrently
+const redisRateRetries = 32
+
+type rateEntry struct {
+	// theoretical arrival time of the token bucket
+	tat time.Time
+	// counts of the sliding window
+	window   int64
+	current  int
+	previous int
+	expires  time.Time
+}
+
+// MemoryRateStore counts in the memory of the instance, the expired keys are removed every minute
+type MemoryRateStore struct {
+	mu        sync.Mutex
+	entries   map[string]*rateEntry
+	nextSweep time.Time
+}
+
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{entries: map[string]*rateEntry{}}
+}
+
+func (s *MemoryRateStore) Take(key string, limit RateLimit) (RateDecision, error) {
+	t := now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(t)
+
+	entry := s.entries[key]
+	if entry == nil {
+		entry = &rateEntry{}
+		s.entries[key] = entry
+	}
+	switch limit.Algorithm {
+	case TokenBucket:
+		decision, tat := takeToken(entry.tat, t, limit)
+		entry.tat, entry.expires = tat, tat
+		return decision, nil
+	case SlidingWindow:
+		window := windowOf(t, limit.Window)
+		switch {
+		case window == entry.window+1:
+			entry.previous, entry.current = entry.current, 0
+		case window != entry.window:
+			entry.previous, entry.current = 0, 0
+		}
+		entry.window = window
+		decision := slideWindow(entry.previous, entry.current+1, t, limit)
+		if decision.Allowed {
+			entry.current++
+		}
+		// the count is kept while it weighs in the next window
+		entry.expires = time.Unix(0, (window+2)*int64(limit.Window))
+		return decision, nil
+	default:
+		return RateDecision{}, fmt.Errorf("unknown rate algorithm `%s`", limit.Algorithm)
+	}
+}
+
+func (s *MemoryRateStore) sweep(t time.Time) {
+	if t.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = t.Add(time.Minute)
+	for key, entry := range s.entries {
+		if !entry.expires.After(t) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// RedisRateStore counts in redis, so that the instances of a cluster share the limits.
+// Token buckets are updated with WATCH and MULTI, windows are counted by INCR
+type RedisRateStore struct {
+	Client *cache.RedisClient
+	// prefix of the redis keys
+	Prefix string
+}
+
+func NewRedisRateStore(client *cache.RedisClient) *RedisRateStore {
+	return &RedisRateStore{Client: client, Prefix: "goze:ratelimit:"}
+}
+
+func (s *RedisRateStore) Take(key string, limit RateLimit) (RateDecision, error) {
+	key = s.Prefix + key
+	switch limit.Algorithm {
+	case TokenBucket:
+		return s.takeToken(key, limit)
+	case SlidingWindow:
+		return s.slideWindow(key, limit)
+	default:
+		return RateDecision{}, fmt.Errorf("unknown rate algorithm `%s`", limit.Algorithm)
+	}
+}
+
+// the tat is stored in unix nanoseconds, it expires when the bucket is full again
+func (s *RedisRateStore) takeToken(key string, limit RateLimit) (RateDecision, error) {
+	var decision RateDecision
+	e := s.Client.WithConn(func(conn redis.Conn) error {
+		for i := 0; i < redisRateRetries; i++ {
+			if _, e := conn.Do("WATCH", key); e != nil {
+				return e
+			}
+			stored, e := redis.Int64(conn.Do("GET", key))
+			if e != nil && !errors.Is(e, redis.ErrNil) {
+				_, _ = conn.Do("UNWATCH")
+				return e
+			}
+			t := now()
+			var tat time.Time
+			decision, tat = takeToken(time.Unix(0, stored), t, limit)
+			if !decision.Allowed {
+				_, e := conn.Do("UNWATCH")
+				return e
+			}
+			if e := conn.Send("MULTI"); e != nil {
+				return e
+			}
+			ttl := tat.Sub(t) / time.Millisecond
+			if e := conn.Send("SET", key, strconv.FormatInt(tat.UnixNano(), 10), "PX", int64(ttl)+1); e != nil {
+				return e
+			}
+			// nil if the key is changed since WATCH
+			reply, e := conn.Do("EXEC")
+			if e != nil {
+				return e
+			}
+			if reply != nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("rate limit key %s is changed concurrently", key)
+	})
+	if e != nil {
+		return RateDecision{}, e
+	}
+	return decision, nil
+}
+
+// the count of the current window is incremented first and taken back if the request is not allowed
+func (s *RedisRateStore) slideWindow(key string, limit RateLimit) (RateDecision, error) {
+	var decision RateDecision
+	e := s.Client.WithConn(func(conn redis.Conn) error {
+		t := now()
+		window := windowOf(t, limit.Window)
+		current := key + ":" + strconv.FormatInt(window, 10)
+		previous := key + ":" + strconv.FormatInt(window-1, 10)
+
+		_ = conn.Send("MULTI")
+		_ = conn.Send("INCR", current)
+		_ = conn.Send("PEXPIRE", current, int64(2*limit.Window/time.Millisecond))
+		_ = conn.Send("GET", previous)
+		replies, e := redis.Values(conn.Do("EXEC"))
+		if e != nil {
+			return e
+		}
+		count, e := redis.Int(replies[0], nil)
+		if e != nil {
+			return e
+		}
+		prev, e := redis.Int(replies[2], nil)
+		if e != nil && !errors.Is(e, redis.ErrNil) {
+			return e
+		}
+		if decision = slideWindow(prev, count, t, limit); !decision.Allowed {
+			_, e := conn.Do("DECR", current)
+			return e
+		}
+		return nil
+	})
+	if e != nil {
+		return RateDecision{}, e
+	}
+	return decision, nil
+}
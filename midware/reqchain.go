@@ -34,6 +34,13 @@ func (r *InterceptorChain) AddInterceptor(interceptor Interceptor) {
 	r.Unlock()
 }
 
+// interceptors of the chain sorted by priority
+func (r *InterceptorChain) Interceptors() []Interceptor {
+	r.Lock()
+	defer r.Unlock()
+	return append([]Interceptor{}, r.interceptor...)
+}
+
 // interceptor chain returns a bool tell if the request should be blocked or resumed
 // second parameter will be treat as the response body
 func (r *InterceptorChain) CallInterceptors(ctx *common.RequestCtx) (bool, interface{}) {
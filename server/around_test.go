@@ -0,0 +1,148 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/midware"
+	"net/http"
+	"testing"
+)
+
+func TestAround(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	var trace []string
+	traced := func(name string) midware.Around {
+		return midware.AroundFunc(func(ctx *common.RequestCtx, next midware.Next) interface{} {
+			trace = append(trace, name)
+			ret := next(ctx)
+			trace = append(trace, "/"+name)
+			return ret
+		})
+	}
+	s.AddAround(traced("server"))
+	// panics of the handler are translated into errors
+	s.AddAround(midware.AroundFunc(func(ctx *common.RequestCtx, next midware.Next) (ret interface{}) {
+		defer func() {
+			if e := recover(); e != nil {
+				ret = NewHTTPError(http.StatusServiceUnavailable, fmt.Sprint(e))
+			}
+		}()
+		return next(ctx)
+	}))
+	s.AddInterceptor(&headerInterceptor{header: "X-Token"})
+
+	api := s.Group("/api")
+	api.AddAround(traced("api"))
+	calls := 0
+	flaky := func(ctx *common.RequestCtx) interface{} {
+		if calls++; calls < 3 {
+			return errors.New("try again")
+		}
+		return "ok"
+	}
+	api.GET("/flaky", flaky)
+	api.GET("/panic", func(ctx *common.RequestCtx) interface{} {
+		panic("down")
+	})
+	// retry on errors
+	retry := api.Group("/retry")
+	retry.AddAround(midware.AroundFunc(func(ctx *common.RequestCtx, next midware.Next) interface{} {
+		ret := next(ctx)
+		for i := 0; i < 3; i++ {
+			if _, ok := ret.(error); !ok {
+				break
+			}
+			ret = next(ctx)
+		}
+		return ret
+	}))
+	retry.GET("/flaky", flaky)
+	// response rewriting
+	rewrite := s.Group("/rewrite")
+	rewrite.AddAround(midware.AroundFunc(func(ctx *common.RequestCtx, next midware.Next) interface{} {
+		return Response{Status: http.StatusAccepted, Header: http.Header{"X-Rewritten": {"1"}},
+			Body: fmt.Sprint("[", next(ctx), "]")}
+	}))
+	rewrite.GET("/", func(ctx *common.RequestCtx) interface{} {
+		return "hello"
+	})
+
+	token := map[string]string{"X-Token": "1"}
+	wr := serve(s, http.MethodGet, "/api/flaky", token)
+	if wr.Code != http.StatusInternalServerError || fmt.Sprint(trace) != "[server api /api /server]" {
+		t.Error("unexpected response", wr.Code, wr.Body.String(), trace)
+	}
+	calls = 0
+	if wr := serve(s, http.MethodGet, "/api/retry/flaky", token); wr.Body.String() != "ok" || calls != 3 {
+		t.Error("request is not retried", wr.Body.String(), calls)
+	}
+	if wr := serve(s, http.MethodGet, "/api/panic", token); wr.Code != http.StatusServiceUnavailable {
+		t.Error("panic is not translated", wr.Code, wr.Body.String())
+	}
+	// interceptors run inside the arounds
+	wr = serve(s, http.MethodGet, "/rewrite", nil)
+	if wr.Code != http.StatusAccepted || wr.Header().Get("X-Rewritten") != "1" || wr.Body.String() != "[Blocked by X-Token]" {
+		t.Error("response is not rewritten", wr.Code, wr.Header(), wr.Body.String())
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	s.AddAround(&midware.CORS{AllowOrigins: []string{"https://example.com"}})
+	s.AddInterceptor(&headerInterceptor{header: "X-Token"})
+	s.PUT("/users/:id", func(ctx *common.RequestCtx) interface{} {
+		return "updated"
+	})
+
+	// preflights carry no credentials, they are answered before the interceptors
+	preflight := map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "PUT"}
+	wr := serve(s, http.MethodOptions, "/users/1", preflight)
+	if wr.Code != http.StatusNoContent || wr.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Error("unexpected preflight", wr.Code, wr.Header())
+	}
+	if wr := serve(s, http.MethodOptions, "/missing", preflight); wr.Code != http.StatusNotFound {
+		t.Error("preflight of an unmapped path is answered", wr.Code)
+	}
+	wr = serve(s, http.MethodPut, "/users/1", map[string]string{"Origin": "https://example.com"})
+	if wr.Body.String() != "Blocked by X-Token" || wr.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Error("unexpected response", wr.Body.String(), wr.Header())
+	}
+
+	// the automatic OPTIONS runs inside the arounds of the route asked for by the preflight,
+	// but not the interceptors of its group
+	s = NewRestServer(":8080", &HttpConfig{})
+	api := s.Group("/api", &headerInterceptor{header: "X-Token"})
+	api.AddAround(&midware.CORS{AllowOrigins: []string{"https://app.com"}})
+	api.GET("/items", func(ctx *common.RequestCtx) interface{} {
+		return "items"
+	})
+	s.POST("/orders", func(ctx *common.RequestCtx) interface{} {
+		return "ordered"
+	}, Arounds(&midware.CORS{AllowOrigins: []string{"https://admin.com"}}))
+	s.GET("/orders", func(ctx *common.RequestCtx) interface{} {
+		return "orders"
+	})
+
+	wr = serve(s, http.MethodOptions, "/api/items", map[string]string{"Origin": "https://app.com",
+		"Access-Control-Request-Method": "GET"})
+	if wr.Code != http.StatusNoContent || wr.Header().Get("Access-Control-Allow-Origin") != "https://app.com" {
+		t.Error("preflight is not answered by the cors of the group", wr.Code, wr.Header())
+	}
+	wr = serve(s, http.MethodOptions, "/orders", map[string]string{"Origin": "https://admin.com",
+		"Access-Control-Request-Method": "POST"})
+	if wr.Code != http.StatusNoContent || wr.Header().Get("Access-Control-Allow-Origin") != "https://admin.com" {
+		t.Error("preflight is not answered by the cors of the route", wr.Code, wr.Header())
+	}
+	wr = serve(s, http.MethodOptions, "/orders", map[string]string{"Origin": "https://admin.com",
+		"Access-Control-Request-Method": "GET"})
+	if wr.Header().Get("Access-Control-Allow-Origin") != "" || wr.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" {
+		t.Error("preflight of another method is answered by the cors of the route", wr.Code, wr.Header())
+	}
+}
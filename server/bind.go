@@ -0,0 +1,192 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"fmt"
+	"github.com/azzill/goze/codec"
+	"github.com/azzill/goze/common"
+	"net/textproto"
+	"reflect"
+)
+
+// BindingError is returned if the request can not be bound to the input of a handler,
+// it is responded as 400 Bad Request
+type BindingError struct {
+	Field  string
+	Source string
+	Err    error
+}
+
+func (e *BindingError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid %s: %v", e.Source, e.Err)
+	}
+	return fmt.Sprintf("invalid %s `%s`: %v", e.Source, e.Field, e.Err)
+}
+
+func (e *BindingError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	ctxType   = reflect.TypeOf(&common.RequestCtx{})
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// convert a handler to RequestHandler, besides RequestHandler itself the signatures below are accepted,
+// In is a struct (or a pointer to it) bound from the request by Bind, Out is any value:
+//
+//	func(ctx *common.RequestCtx, in In) (Out, error)
+//	func(ctx *common.RequestCtx, in In) error
+//	func(ctx *common.RequestCtx, in In) Out
+//	func(ctx *common.RequestCtx) (Out, error)
+//
+// a non-nil error is returned as the result so that the transaction is rolled back,
+// the bound input is validated by the validator before the handler is called
+func adaptHandler(handler interface{}, validator *Validator) (RequestHandler, error) {
+	switch h := handler.(type) {
+	case RequestHandler:
+		return h, nil
+	case func(ctx *common.RequestCtx) interface{}:
+		return h, nil
+	}
+
+	fn := reflect.ValueOf(handler)
+	if handler == nil || fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil, fmt.Errorf("handler must be a function but got %T", handler)
+	}
+	t := fn.Type()
+	if t.IsVariadic() || t.NumIn() < 1 || t.NumIn() > 2 || t.In(0) != ctxType {
+		return nil, fmt.Errorf("handler %v must accept *common.RequestCtx and an optional input", t)
+	}
+	if t.NumOut() > 2 || t.NumOut() == 2 && t.Out(1) != errorType {
+		return nil, fmt.Errorf("handler %v must return (value, error), error or a value", t)
+	}
+
+	var in reflect.Type
+	if t.NumIn() == 2 {
+		in = t.In(1)
+		if in.Kind() == reflect.Ptr {
+			in = in.Elem()
+		}
+		if in.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("input of handler %v must be a struct", t)
+		}
+		if e := validator.Check(in); e != nil {
+			return nil, e
+		}
+	}
+
+	return func(ctx *common.RequestCtx) interface{} {
+		args := []reflect.Value{reflect.ValueOf(ctx)}
+		if in != nil {
+			v := reflect.New(in)
+			if e := Bind(ctx, v.Interface()); e != nil {
+				return e
+			}
+			if e := validator.Validate(v.Interface()); e != nil {
+				return e
+			}
+			if t.In(1).Kind() != reflect.Ptr {
+				v = v.Elem()
+			}
+			args = append(args, v)
+		}
+
+		out := fn.Call(args)
+		switch len(out) {
+		case 0:
+			return nil
+		case 1:
+			return out[0].Interface()
+		default:
+			if e := out[1].Interface(); e != nil {
+				return e
+			}
+			return out[0].Interface()
+		}
+	}, nil
+}
+
+// bind the request to the struct pointed by dst, the body is parsed first, then the fields tagged
+// with `path:"name"`, `query:"name"` and `header:"Name"` are filled, they are never set by the body
+func Bind(ctx *common.RequestCtx, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind target must be a pointer to struct but got %T", dst)
+	}
+
+	if ctx.Request.Body != nil && ctx.Request.ContentLength != 0 {
+		if e := ctx.ParseBody(dst); e != nil {
+			return &BindingError{Source: "body", Err: e}
+		}
+		// the fields of the path, query and headers are never taken from the body
+		clearBound(v.Elem())
+	}
+	return bindFields(ctx, v.Elem())
+}
+
+func clearBound(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue //unexported
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			clearBound(v.Field(i))
+			continue
+		}
+		if field.Tag.Get("path") != "" || field.Tag.Get("query") != "" || field.Tag.Get("header") != "" {
+			v.Field(i).Set(reflect.Zero(field.Type))
+		}
+	}
+}
+
+func bindFields(ctx *common.RequestCtx, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue //unexported
+		}
+
+		// embedded structs are bound recursively
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if e := bindFields(ctx, v.Field(i)); e != nil {
+				return e
+			}
+			continue
+		}
+
+		var values []string
+		var source, name string
+		if name = field.Tag.Get("path"); name != "" {
+			source = "path variable"
+			if pv, has := ctx.PathVariable[name]; has {
+				values = []string{pv}
+			}
+		} else if name = field.Tag.Get("query"); name != "" {
+			source = "query"
+			values = ctx.QueryString[name]
+		} else if name = field.Tag.Get("header"); name != "" {
+			source = "header"
+			values = ctx.Request.Header[textproto.CanonicalMIMEHeaderKey(name)]
+		} else {
+			continue
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+		if e := codec.SetValues(v.Field(i), values); e != nil {
+			return &BindingError{Field: name, Source: source, Err: e}
+		}
+	}
+	return nil
+}
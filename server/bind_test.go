@@ -0,0 +1,229 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/azzill/goze/common"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type pagination struct {
+	Page int `query:"page"`
+}
+
+type updateUserReq struct {
+	pagination
+	Id     int64    `path:"id"`
+	Tenant string   `header:"x-tenant"`
+	Tags   []string `query:"tag"`
+	Limit  *uint    `query:"limit"`
+	Name   string   `json:"name"`
+}
+
+type user struct {
+	Id     int64    `json:"id"`
+	Name   string   `json:"name"`
+	Tenant string   `json:"tenant"`
+	Tags   []string `json:"tags"`
+	Page   int      `json:"page"`
+	Limit  uint     `json:"limit"`
+}
+
+func TestTypedHandler(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	s.PUT("/users/{id:int}", func(ctx *common.RequestCtx, in updateUserReq) (*user, error) {
+		if in.Name == "" {
+			return nil, errors.New("empty name")
+		}
+		return &user{Id: in.Id, Name: in.Name, Tenant: in.Tenant, Tags: in.Tags, Page: in.Page, Limit: *in.Limit}, nil
+	})
+	s.DELETE("/users/:id", func(ctx *common.RequestCtx, in *updateUserReq) error {
+		return nil
+	})
+	s.GET("/users", func(ctx *common.RequestCtx) interface{} {
+		return nil
+	})
+	s.GET("/invalid", func(in updateUserReq) error {
+		return nil
+	})
+	if len(s.Conflicts()) != 1 {
+		t.Error("invalid handler is not reported", s.Conflicts())
+	}
+
+	put := func(url string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, url, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Tenant", "goze")
+		wr := httptest.NewRecorder()
+		s.controller.ServeHTTP(wr, r)
+		return wr
+	}
+
+	wr := put("/users/42?tag=a&tag=b&page=2&limit=10", `{"name":"azz"}`)
+	u := user{}
+	if e := json.Unmarshal(wr.Body.Bytes(), &u); e != nil {
+		t.Fatal(e, wr.Body.String())
+	}
+	if u.Id != 42 || u.Name != "azz" || u.Tenant != "goze" || len(u.Tags) != 2 || u.Page != 2 || u.Limit != 10 {
+		t.Error("unexpected binding", u)
+	}
+
+	// the body cannot set the fields of the path, query and headers
+	r := httptest.NewRequest(http.MethodPut, "/users/42?limit=1",
+		strings.NewReader(`{"name":"azz","Tenant":"other","Id":7,"Tags":["x"],"Page":9}`))
+	r.Header.Set("Content-Type", "application/json")
+	wr = httptest.NewRecorder()
+	s.controller.ServeHTTP(wr, r)
+	u = user{}
+	if e := json.Unmarshal(wr.Body.Bytes(), &u); e != nil {
+		t.Fatal(e, wr.Body.String())
+	}
+	if u.Id != 42 || u.Tenant != "" || len(u.Tags) != 0 || u.Page != 0 {
+		t.Error("bound fields are set from the body", u)
+	}
+
+	if wr := put("/users/42?limit=-1", `{"name":"azz"}`); wr.Code != http.StatusBadRequest {
+		t.Error("expected 400 but got", wr.Code)
+	}
+	if wr := put("/users/42?limit=1", `{"name":""}`); wr.Code != http.StatusInternalServerError {
+		t.Error("expected 500 but got", wr.Code)
+	}
+	if wr := serve(s, http.MethodDelete, "/users/42", nil); wr.Code != http.StatusOK {
+		t.Error("expected 200 but got", wr.Code)
+	}
+}
+
+type address struct {
+	City string `json:"city" validate:"required"`
+}
+
+type createUserReq struct {
+	Name    string   `json:"name" validate:"required,min=1,max=8"`
+	Email   string   `json:"email" validate:"required,email"`
+	Age     *int     `json:"age" validate:"min=18"`
+	Role    string   `json:"role" validate:"oneof=admin user"`
+	Tags    []string `json:"tags" validate:"max=2"`
+	Code    string   `json:"code" validate:"even"`
+	Nick    *string  `json:"nick" validate:"regexp=^a{1,3}$"`
+	Address *address `json:"address"`
+}
+
+func TestValidation(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	s.AddValidator("even", func(v reflect.Value, param string) bool {
+		return len(v.String())%2 == 0
+	})
+	s.POST("/users", func(ctx *common.RequestCtx, in createUserReq) (string, error) {
+		return in.Name, nil
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		wr := httptest.NewRecorder()
+		s.controller.ServeHTTP(wr, r)
+		return wr
+	}
+
+	if wr := post(`{"name":"azz","email":"azz@goze.io","role":"admin","code":"ab","nick":"aa"}`); wr.Code != http.StatusOK ||
+		wr.Body.String() != "azz" {
+		t.Error("expected 200 but got", wr.Code, wr.Body.String())
+	}
+
+	wr := post(`{"name":"too long name","email":"azz","age":17,"role":"root","tags":["a","b","c"],"code":"a",
+		"nick":"aaaa","address":{}}`)
+	if wr.Code != http.StatusBadRequest {
+		t.Fatal("expected 400 but got", wr.Code, wr.Body.String())
+	}
+	errs := struct {
+		Fields []FieldError `json:"details"`
+	}{}
+	if e := json.Unmarshal(wr.Body.Bytes(), &errs); e != nil {
+		t.Fatal(e)
+	}
+	expected := []string{"name:max", "email:email", "age:min", "role:oneof", "tags:max", "code:even", "nick:regexp",
+		"address.city:required"}
+	if len(errs.Fields) != len(expected) {
+		t.Fatal("expected", expected, "but got", errs.Fields)
+	}
+	for i, f := range errs.Fields {
+		if f.Field+":"+f.Rule != expected[i] {
+			t.Error("expected", expected[i], "but got", f)
+		}
+	}
+}
+
+type typoReq struct {
+	Address *struct {
+		City string `json:"city" validate:"requird"`
+	} `json:"address"`
+}
+
+func TestUnknownRule(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	s.POST("/typo", func(ctx *common.RequestCtx, in *typoReq) error {
+		return nil
+	})
+	conflicts := s.Conflicts()
+	if len(conflicts) != 1 || !strings.Contains(conflicts[0].Reason, "requird") {
+		t.Fatal("unknown rule is not rejected", conflicts)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("server started with an unknown rule")
+		}
+	}()
+	s.StartServerAsync()
+}
+
+func TestContentNegotiation(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	s.POST("/users", func(ctx *common.RequestCtx, in updateUserReq) *user {
+		return &user{Name: in.Name}
+	})
+
+	post := func(contentType string, accept string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Accept", accept)
+		wr := httptest.NewRecorder()
+		s.controller.ServeHTTP(wr, r)
+		return wr
+	}
+
+	if wr := post("application/json; charset=utf-8", "", `{"name":"azz"}`); wr.Body.String() != `{"id":0,"name":"azz","tenant":"","tags":null,"page":0,"limit":0}` {
+		t.Error("unexpected json response", wr.Body.String())
+	}
+	if wr := post("application/x-www-form-urlencoded", "application/xml", "name=azz"); wr.Header().Get("Content-Type") != "application/xml" ||
+		!strings.Contains(wr.Body.String(), "<Name>azz</Name>") {
+		t.Error("unexpected xml response", wr.Body.String())
+	}
+	if wr := post("text/csv", "", "azz"); wr.Code != http.StatusUnsupportedMediaType {
+		t.Error("expected 415 but got", wr.Code)
+	}
+	if wr := post("application/json", "text/html", `{"name":"azz"}`); wr.Code != http.StatusNotAcceptable {
+		t.Error("expected 406 but got", wr.Code)
+	}
+
+	// a map cannot be encoded by XML, the next acceptable codec is used
+	s.GET("/attrs", func(ctx *common.RequestCtx) interface{} {
+		return map[string]string{"name": "azz"}
+	})
+	r := httptest.NewRequest(http.MethodGet, "/attrs", nil)
+	r.Header.Set("Accept", "application/xml, application/json;q=0.5")
+	wr := httptest.NewRecorder()
+	s.controller.ServeHTTP(wr, r)
+	if wr.Code != http.StatusOK || wr.Header().Get("Content-Type") != "application/json" || wr.Body.String() != `{"name":"azz"}` {
+		t.Error("unexpected fallback", wr.Code, wr.Header(), wr.Body.String())
+	}
+}
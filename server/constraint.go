@@ -0,0 +1,80 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// constraint of a typed placeholder such as {id:int} or {name:[a-z]+\.txt}
+type pathConstraint struct {
+	expr  string
+	match func(s string) bool
+}
+
+var uuidRegexp = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// built-in placeholder types, any other expression is treated as a regexp
+var pathTypes = map[string]func(s string) bool{
+	"int": func(s string) bool {
+		_, e := strconv.ParseInt(s, 10, 64)
+		return e == nil
+	},
+	"uint": func(s string) bool {
+		_, e := strconv.ParseUint(s, 10, 64)
+		return e == nil
+	},
+	"float": func(s string) bool {
+		_, e := strconv.ParseFloat(s, 64)
+		return e == nil
+	},
+	"bool": func(s string) bool {
+		_, e := strconv.ParseBool(s)
+		return e == nil
+	},
+	"uuid":  uuidRegexp.MatchString,
+	"alpha": regexp.MustCompile("^[a-zA-Z]+$").MatchString,
+	"alnum": regexp.MustCompile("^[a-zA-Z0-9]+$").MatchString,
+}
+
+// parse a placeholder segment: ":name", "{name}" or "{name:constraint}",
+// ok is false if the segment is a static one
+func parsePlaceholder(segment string) (name string, constraint *pathConstraint, ok bool, err error) {
+	if len(segment) > 0 && segment[0] == ':' {
+		return segment[1:], nil, true, nil
+	}
+	if len(segment) < 2 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+		return "", nil, false, nil
+	}
+	inner := segment[1 : len(segment)-1]
+	i := strings.IndexByte(inner, ':')
+	if i < 0 {
+		return inner, nil, true, nil
+	}
+	name, expr := inner[:i], inner[i+1:]
+	if expr == "" {
+		return name, nil, true, nil
+	}
+	if match, has := pathTypes[expr]; has {
+		return name, &pathConstraint{expr: expr, match: match}, true, nil
+	}
+	r, e := regexp.Compile("^(?:" + expr + ")$")
+	if e != nil {
+		return name, nil, true, fmt.Errorf("invalid constraint of placeholder `%s`: %v", name, e)
+	}
+	return name, &pathConstraint{expr: expr, match: r.MatchString}, true, nil
+}
+
+func (c *pathConstraint) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.expr
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/codec"
+	"github.com/azzill/goze/midware"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTPError is an error responded with its status, handlers can return it directly
+// or wrap it with fmt.Errorf("...: %w", err), it is found by errors.As
+type HTTPError struct {
+	Status int
+	// machine readable code of the error, eg: user_not_found
+	Code    string
+	Message string
+	Details interface{}
+	Cause   error
+}
+
+func NewHTTPError(status int, message string) *HTTPError {
+	return &HTTPError{Status: status, Message: message}
+}
+
+func (e *HTTPError) Error() string {
+	s := fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.Cause != nil {
+		s += ": " + e.Cause.Error()
+	}
+	return s
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *HTTPError) WithCode(code string) *HTTPError {
+	e.Code = code
+	return e
+}
+
+func (e *HTTPError) WithDetails(details interface{}) *HTTPError {
+	e.Details = details
+	return e
+}
+
+func (e *HTTPError) Wrap(cause error) *HTTPError {
+	e.Cause = cause
+	return e
+}
+
+func BadRequest(message string) *HTTPError {
+	return NewHTTPError(http.StatusBadRequest, message)
+}
+
+func Unauthorized(message string) *HTTPError {
+	return NewHTTPError(http.StatusUnauthorized, message)
+}
+
+func Forbidden(message string) *HTTPError {
+	return NewHTTPError(http.StatusForbidden, message)
+}
+
+func NotFound(message string) *HTTPError {
+	return NewHTTPError(http.StatusNotFound, message)
+}
+
+func Conflict(message string) *HTTPError {
+	return NewHTTPError(http.StatusConflict, message)
+}
+
+func PayloadTooLarge(message string) *HTTPError {
+	return NewHTTPError(http.StatusRequestEntityTooLarge, message)
+}
+
+func InternalServerError(message string) *HTTPError {
+	return NewHTTPError(http.StatusInternalServerError, message)
+}
+
+// ErrorMapper converts a domain error to HTTPError, nil if it does not know the error
+type ErrorMapper func(e error) *HTTPError
+
+// respond the errors matching target (by errors.Is) with the status
+// eg: s.MapError(sql.ErrNoRows, http.StatusNotFound)
+func (s *RestServer) MapError(target error, status int) {
+	s.AddErrorMapper(mapError(target, status, ""))
+}
+
+func mapError(target error, status int, code string) ErrorMapper {
+	return func(e error) *HTTPError {
+		if errors.Is(e, target) {
+			return NewHTTPError(status, "").WithCode(code).Wrap(e)
+		}
+		return nil
+	}
+}
+
+// the errors of the framework packages, tried after the mappers of the server so that they can be overridden
+var defaultErrorMappers = []ErrorMapper{
+	mapError(codec.ErrFileTooLarge, http.StatusRequestEntityTooLarge, "file_too_large"),
+	mapError(codec.ErrTooManyFiles, http.StatusRequestEntityTooLarge, "too_many_files"),
+	mapError(codec.ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, ""),
+	mapError(codec.ErrNotAcceptable, http.StatusNotAcceptable, ""),
+	mapError(midware.ErrRateLimited, http.StatusTooManyRequests, "rate_limited"),
+}
+
+// mappers are tried in the order they are added if the error does not wrap a HTTPError
+func (s *RestServer) AddErrorMapper(mapper ErrorMapper) {
+	s.controller.errorMappers = append(s.controller.errorMappers, mapper)
+}
+
+// ErrorRenderer writes HTTPError to the response
+type ErrorRenderer interface {
+	Render(wr http.ResponseWriter, r *http.Request, e *HTTPError)
+}
+
+func (s *RestServer) SetErrorRenderer(renderer ErrorRenderer) {
+	s.controller.errorRenderer = renderer
+}
+
+// convert any error to HTTPError, unknown errors are 500 Internal Server Error
+// whose detail never tells the cause, it is only logged
+func (c *RestController) toHTTPError(e error) *HTTPError {
+	var he *HTTPError
+	if errors.As(e, &he) {
+		return he
+	}
+	for _, mapper := range c.errorMappers {
+		if he := mapper(e); he != nil {
+			return he
+		}
+	}
+	for _, mapper := range defaultErrorMappers {
+		if he := mapper(e); he != nil {
+			return he
+		}
+	}
+
+	var ve *ValidationError
+	if errors.As(e, &ve) {
+		return BadRequest("validation failed").WithCode("validation_failed").WithDetails(ve.Fields).Wrap(e)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(e, &tooLarge) {
+		return bodyTooLarge(tooLarge.Limit, e)
+	}
+	var be *BindingError
+	if errors.As(e, &be) {
+		return BadRequest(be.Error()).WithCode("binding_failed").Wrap(e)
+	}
+	return InternalServerError(http.StatusText(http.StatusInternalServerError)).Wrap(e)
+}
+
+func bodyTooLarge(limit int64, cause error) *HTTPError {
+	he := PayloadTooLarge(fmt.Sprintf("request body exceeds %d bytes", limit)).WithCode("body_too_large")
+	if cause != nil {
+		he.Wrap(cause)
+	}
+	return he
+}
+
+func (c *RestController) renderError(wr http.ResponseWriter, r *http.Request, e error) {
+	he := c.toHTTPError(e)
+	if he.Status >= http.StatusInternalServerError {
+		logger.Error(r.Method, r.URL.Path, "-", e.Error())
+	}
+	c.errorRenderer.Render(wr, r, he)
+}
+
+// ProblemRenderer renders errors as RFC 7807 problem details (application/problem+json),
+// or as the html error page if the client prefers text/html
+type ProblemRenderer struct{}
+
+// RFC 7807 problem details, Details of HTTPError is the `details` extension member
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     string      `json:"code,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+func (ProblemRenderer) Render(wr http.ResponseWriter, r *http.Request, e *HTTPError) {
+	message := e.Message
+	if message == "" && e.Cause != nil {
+		message = e.Cause.Error()
+	}
+
+	if prefersHTML(r.Header.Get("Accept")) {
+		HttpError(wr, e.Status, message, false)
+		return
+	}
+
+	bytes, err := json.Marshal(Problem{Type: "about:blank", Title: http.StatusText(e.Status), Status: e.Status,
+		Detail: message, Instance: r.URL.Path, Code: e.Code, Details: e.Details})
+	if err != nil {
+		HttpError(wr, e.Status, message, false)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/problem+json")
+	wr.Header().Set("X-Content-Type-Options", "nosniff")
+	wr.WriteHeader(e.Status)
+	_, _ = wr.Write(bytes)
+}
+
+// whether text/html is accepted with a higher quality than json
+func prefersHTML(accept string) bool {
+	htmlQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, e := mime.ParseMediaType(strings.TrimSpace(part))
+		if e != nil {
+			continue
+		}
+		q, e := strconv.ParseFloat(params["q"], 64)
+		if e != nil {
+			q = 1.0
+		}
+		switch {
+		case mediaType == "text/html" && q > htmlQ:
+			htmlQ = q
+		case strings.HasSuffix(mediaType, "json") && q > jsonQ:
+			jsonQ = q
+		}
+	}
+	return htmlQ > 0 && htmlQ > jsonQ
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/midware"
+	"github.com/azzill/goze/sql"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errNoUser = errors.New("no such user")
+
+type recordTx struct {
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *recordTx) Commit() error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *recordTx) Rollback() error {
+	tx.rolledBack = true
+	return nil
+}
+
+func TestHTTPError(t *testing.T) {
+	s := NewRestServer(":8080", nil)
+	s.MapError(errNoUser, http.StatusNotFound)
+
+	tx := &recordTx{}
+	s.GET("/users/{id:int}", func(ctx *common.RequestCtx) interface{} {
+		ctx.Tx = sql.NewTx(nil, tx)
+		if ctx.PathInt("id") == 42 {
+			return NotFound("user 42").WithCode("user_not_found")
+		}
+		return fmt.Errorf("find user %d: %w", ctx.PathInt("id"), errNoUser)
+	})
+	s.GET("/wrapped", func(ctx *common.RequestCtx) interface{} {
+		return fmt.Errorf("outer: %w", Conflict("taken").WithDetails(map[string]string{"name": "goze"}))
+	})
+	s.GET("/fail", func(ctx *common.RequestCtx) interface{} {
+		return errors.New("boom")
+	})
+
+	wr := serve(s, "GET", "/users/42", nil)
+	if wr.Code != http.StatusNotFound || wr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatal("unexpected response", wr.Code, wr.Header())
+	}
+	problem := Problem{}
+	if e := json.Unmarshal(wr.Body.Bytes(), &problem); e != nil {
+		t.Fatal(e)
+	}
+	if problem.Status != 404 || problem.Code != "user_not_found" || problem.Detail != "user 42" ||
+		problem.Title != "Not Found" || problem.Instance != "/users/42" {
+		t.Error("unexpected problem", problem)
+	}
+	if !tx.rolledBack || tx.committed {
+		t.Error("transaction is not rolled back on error")
+	}
+
+	// domain error mapped by MapError
+	if wr := serve(s, "GET", "/users/7", nil); wr.Code != http.StatusNotFound {
+		t.Error("mapped error responded with", wr.Code)
+	}
+
+	// HTTPError wrapped by another error
+	wr = serve(s, "GET", "/wrapped", nil)
+	problem = Problem{}
+	_ = json.Unmarshal(wr.Body.Bytes(), &problem)
+	if wr.Code != http.StatusConflict || problem.Details == nil {
+		t.Error("unexpected response of wrapped error", wr.Code, wr.Body.String())
+	}
+
+	// browsers get the html page
+	wr = serve(s, "GET", "/users/42", map[string]string{"Accept": "text/html,application/xhtml+xml,*/*;q=0.8"})
+	if wr.Code != http.StatusNotFound || !strings.HasPrefix(wr.Header().Get("Content-Type"), "text/html") {
+		t.Error("html is not rendered for browsers", wr.Code, wr.Header())
+	}
+
+	// the cause of unknown errors is only logged
+	if wr := serve(s, "GET", "/fail", nil); wr.Code != http.StatusInternalServerError ||
+		strings.Contains(wr.Body.String(), "boom") {
+		t.Error("unknown error responded with", wr.Code, wr.Body.String())
+	}
+	if wr := serve(s, "GET", "/none", nil); wr.Code != http.StatusNotFound {
+		t.Error("unmapped url responded with", wr.Code)
+	}
+}
+
+type textRenderer struct{}
+
+func (textRenderer) Render(wr http.ResponseWriter, r *http.Request, e *HTTPError) {
+	wr.WriteHeader(e.Status)
+	_, _ = wr.Write([]byte(e.Code))
+}
+
+func TestErrorRenderer(t *testing.T) {
+	s := NewRestServer(":8080", nil)
+	s.SetErrorRenderer(textRenderer{})
+	s.GET("/", func(ctx *common.RequestCtx) interface{} {
+		return Forbidden("").WithCode("forbidden")
+	})
+	if wr := serve(s, "GET", "/", nil); wr.Code != http.StatusForbidden || wr.Body.String() != "forbidden" {
+		t.Error("custom renderer is not used", wr.Code, wr.Body.String())
+	}
+}
+
+// the rate limited requests of a route get 429 Too Many Requests
+func TestRateLimit(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	hello := func(ctx *common.RequestCtx) interface{} {
+		return "hello"
+	}
+	s.GET("/limited", hello, Arounds(&midware.RateLimiter{Limit: 1, Window: time.Minute}))
+	s.GET("/free", hello)
+
+	if wr := serve(s, http.MethodGet, "/limited", nil); wr.Code != http.StatusOK || wr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Error("unexpected response", wr.Code, wr.Header())
+	}
+	wr := serve(s, http.MethodGet, "/limited", nil)
+	problem := map[string]interface{}{}
+	_ = json.Unmarshal(wr.Body.Bytes(), &problem)
+	if wr.Code != http.StatusTooManyRequests || wr.Header().Get("Retry-After") == "" || problem["code"] != "rate_limited" {
+		t.Error("request is not limited", wr.Code, wr.Header(), wr.Body.String())
+	}
+	if wr := serve(s, http.MethodGet, "/free", nil); wr.Code != http.StatusOK || wr.Header().Get("RateLimit-Limit") != "" {
+		t.Error("limit of another route is applied", wr.Code, wr.Header())
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/midware"
+	"strings"
+)
+
+// RouteGroup is a sub-router of RestServer, every route registered through it
+// shares the group prefix and is intercepted by the interceptors of the group
+// (and of its parents) after the global ones
+type RouteGroup struct {
+	server      *RestServer
+	parent      *RouteGroup
+	prefix      string
+	interceptor midware.InterceptorChain
+	arounds     midware.AroundChain
+}
+
+func (s *RestServer) Group(prefix string, interceptors ...midware.Interceptor) *RouteGroup {
+	return newRouteGroup(s, nil, prefix, interceptors)
+}
+
+func (g *RouteGroup) Group(prefix string, interceptors ...midware.Interceptor) *RouteGroup {
+	return newRouteGroup(g.server, g, prefix, interceptors)
+}
+
+func newRouteGroup(s *RestServer, parent *RouteGroup, prefix string, interceptors []midware.Interceptor) *RouteGroup {
+	if parent != nil {
+		prefix = joinPattern(parent.prefix, prefix)
+	} else {
+		prefix = joinPattern("", prefix)
+	}
+	g := &RouteGroup{server: s, parent: parent, prefix: prefix}
+	for _, i := range interceptors {
+		g.interceptor.AddInterceptor(i)
+	}
+	return g
+}
+
+func (g *RouteGroup) GET(pattern string, handler interface{}, options ...RouteOption) *RouteGroup {
+	return g.Mapping(Get, pattern, handler, options...)
+}
+func (g *RouteGroup) POST(pattern string, handler interface{}, options ...RouteOption) *RouteGroup {
+	return g.Mapping(Post, pattern, handler, options...)
+}
+func (g *RouteGroup) DELETE(pattern string, handler interface{}, options ...RouteOption) *RouteGroup {
+	return g.Mapping(Delete, pattern, handler, options...)
+}
+func (g *RouteGroup) PUT(pattern string, handler interface{}, options ...RouteOption) *RouteGroup {
+	return g.Mapping(Put, pattern, handler, options...)
+}
+func (g *RouteGroup) PATCH(pattern string, handler interface{}, options ...RouteOption) *RouteGroup {
+	return g.Mapping(Patch, pattern, handler, options...)
+}
+
+func (g *RouteGroup) Mapping(method RequestMethod, pattern string, handler interface{}, options ...RouteOption) *RouteGroup {
+	g.server.mapping(method, joinPattern(g.prefix, pattern), handler, g, options)
+	return g
+}
+
+// interceptors added here only apply to the routes of this group and its sub groups
+func (g *RouteGroup) AddInterceptor(interceptor midware.Interceptor) {
+	g.interceptor.AddInterceptor(interceptor)
+}
+
+// arounds added here only wrap the routes of this group and its sub groups,
+// inside the arounds of the server and of the parent groups
+func (g *RouteGroup) AddAround(around midware.Around) {
+	g.arounds.AddAround(around)
+}
+
+func (g *RouteGroup) wrapArounds(next midware.Next) midware.Next {
+	next = g.arounds.Wrap(next)
+	if g.parent != nil {
+		return g.parent.wrapArounds(next)
+	}
+	return next
+}
+
+// the arounds of the group and of its parents, the outermost first
+func (g *RouteGroup) allArounds() []midware.Around {
+	var arounds []midware.Around
+	if g.parent != nil {
+		arounds = g.parent.allArounds()
+	}
+	return append(arounds, g.arounds.Arounds()...)
+}
+
+// call interceptors from the outermost group to the innermost one,
+// Skip only skips the remaining interceptors of the same group
+func (g *RouteGroup) callInterceptors(ctx *common.RequestCtx) (bool, interface{}) {
+	if g.parent != nil {
+		if intercepted, obj := g.parent.callInterceptors(ctx); intercepted {
+			return true, obj
+		}
+	}
+	return g.interceptor.CallInterceptors(ctx)
+}
+
+// join a group prefix and a pattern, the result always starts with '/' and never ends with it
+func joinPattern(prefix string, pattern string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+	switch {
+	case prefix == "":
+		return "/" + pattern
+	case pattern == "":
+		return "/" + prefix
+	default:
+		return "/" + prefix + "/" + pattern
+	}
+}
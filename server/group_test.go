@@ -0,0 +1,75 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/midware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type headerInterceptor struct {
+	header string
+}
+
+func (*headerInterceptor) Priority() int {
+	return 0
+}
+
+func (i *headerInterceptor) Intercept(ctx *common.RequestCtx) (midware.InterceptorAction, interface{}) {
+	if ctx.Request.Header.Get(i.header) == "" {
+		return midware.Block, "Blocked by " + i.header
+	}
+	return midware.Continue, nil
+}
+
+func serve(s *RestServer, method string, url string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	wr := httptest.NewRecorder()
+	s.controller.ServeHTTP(wr, r)
+	return wr
+}
+
+func TestRouteGroup(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	hello := func(ctx *common.RequestCtx) interface{} {
+		return "Hello " + ctx.PathVariable["name"]
+	}
+
+	s.GET("/public/:name", hello)
+	api := s.Group("/api/v1/")
+	api.GET("/:name", hello)
+	admin := api.Group("admin", &headerInterceptor{header: "X-Admin"})
+	admin.GET("/:name", hello)
+	admin.Group("/root", &headerInterceptor{header: "X-Root"}).GET("/:name", hello)
+
+	cases := []struct {
+		url    string
+		header map[string]string
+		body   string
+	}{
+		{"/public/goze", nil, "Hello goze"},
+		{"/api/v1/goze", nil, "Hello goze"},
+		{"/api/v1/admin/goze", nil, "Blocked by X-Admin"},
+		{"/api/v1/admin/goze", map[string]string{"X-Admin": "1"}, "Hello goze"},
+		{"/api/v1/admin/root/goze", map[string]string{"X-Root": "1"}, "Blocked by X-Admin"},
+		{"/api/v1/admin/root/goze", map[string]string{"X-Admin": "1"}, "Blocked by X-Root"},
+		{"/api/v1/admin/root/goze", map[string]string{"X-Admin": "1", "X-Root": "1"}, "Hello goze"},
+	}
+
+	for _, c := range cases {
+		wr := serve(s, http.MethodGet, c.url, c.header)
+		if wr.Body.String() != c.body {
+			t.Error(c.url, c.header, "expected", c.body, "but got", wr.Body.String())
+		}
+	}
+}
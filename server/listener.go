@@ -0,0 +1,230 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	NetworkTCP     = "tcp"
+	NetworkUnix    = "unix"
+	NetworkSystemd = "systemd"
+)
+
+// the first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// ListenerConfig is a listener served besides the address of the server
+type ListenerConfig struct {
+	// tcp, tcp4, tcp6, unix or systemd, tcp by default
+	Network string
+	// host:port for tcp, the socket path for unix, the name (LISTEN_FDNAMES) or the index of
+	// the activated socket for systemd, the first activated socket if empty
+	Address string
+	// the route set served on the listener, the routes of the server if empty
+	RouteSet string
+	// serve https with the TLSConfig of the server
+	TLS bool
+	// permission of the unix socket file, eg: 0660, left to the umask if zero
+	Mode os.FileMode
+}
+
+func (l ListenerConfig) network() string {
+	if l.Network == "" {
+		return NetworkTCP
+	}
+	return l.Network
+}
+
+func (l ListenerConfig) String() string {
+	return l.network() + ":" + l.Address
+}
+
+func (l ListenerConfig) listen() (net.Listener, error) {
+	switch network := l.network(); network {
+	case "tcp", "tcp4", "tcp6":
+		return net.Listen(network, l.Address)
+	case NetworkUnix:
+		return listenUnix(l.Address, l.Mode)
+	case NetworkSystemd:
+		return activatedListener(l.Address)
+	default:
+		return nil, fmt.Errorf("unknown network `%s`", network)
+	}
+}
+
+// the socket file left by a crashed process is removed, a socket still accepting connections is not
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if stat, e := os.Stat(path); e == nil && stat.Mode()&os.ModeSocket != 0 {
+		if conn, e := net.Dial(NetworkUnix, path); e == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if e := os.Remove(path); e != nil {
+			return nil, e
+		}
+	}
+	// the socket file is removed when the listener is closed
+	listener, e := net.Listen(NetworkUnix, path)
+	if e != nil {
+		return nil, e
+	}
+	if mode != 0 {
+		if e := os.Chmod(path, mode); e != nil {
+			_ = listener.Close()
+			return nil, e
+		}
+	}
+	return listener, nil
+}
+
+type passedSocket struct {
+	name     string
+	listener net.Listener
+	used     bool
+}
+
+// sockets passed by another process, they are loaded once since the environment is cleared
+type socketSet struct {
+	once    sync.Once
+	mu      sync.Mutex
+	load    func() ([]*passedSocket, error)
+	sockets []*passedSocket
+	err     error
+}
+
+var activation = &socketSet{load: activatedSockets}
+
+// the listener of the socket by its name or index, nil if it is not found, every socket is taken once
+func (s *socketSet) take(name string) (net.Listener, error) {
+	s.once.Do(func() {
+		s.sockets, s.err = s.load()
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, socket := range s.sockets {
+		if socket.name != name && strconv.Itoa(i) != name {
+			continue
+		}
+		if socket.used {
+			return nil, fmt.Errorf("socket `%s` is already in use", name)
+		}
+		socket.used = true
+		return socket.listener, nil
+	}
+	return nil, nil
+}
+
+// the socket of systemd socket activation by its name or index, the first one if name is empty
+func activatedListener(name string) (net.Listener, error) {
+	if name == "" {
+		name = "0"
+	}
+	listener, e := activation.take(name)
+	if e != nil {
+		return nil, e
+	}
+	if listener == nil {
+		return nil, fmt.Errorf("activated socket `%s` is not found", name)
+	}
+	return listener, nil
+}
+
+// sockets are passed as the file descriptors from 3 on, LISTEN_PID is checked so that the
+// sockets of a parent process are not taken, the variables are unset to keep them from children
+func activatedSockets() ([]*passedSocket, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" {
+		return nil, nil
+	}
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, e := strconv.Atoi(fds)
+	if e != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS `%s`", fds)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+	return fileSockets(listenFdsStart, count, fdNames)
+}
+
+func fileSockets(start int, count int, names []string) ([]*passedSocket, error) {
+	sockets := make([]*passedSocket, 0, count)
+	for i := 0; i < count; i++ {
+		fd := start + i
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		// the listener holds a duplicate of the descriptor
+		listener, e := net.FileListener(f)
+		_ = f.Close()
+		if e != nil {
+			return nil, fmt.Errorf("fd %d is not a listening socket: %v", fd, e)
+		}
+		sockets = append(sockets, &passedSocket{name: name, listener: listener})
+	}
+	return sockets, nil
+}
+
+// serve the listener besides the address of the server
+func (s *RestServer) AddListener(listener ListenerConfig) *RestServer {
+	s.listeners = append(s.listeners, listener)
+	return s
+}
+
+// RouteSet is a named set of routes served only on the listeners naming it, eg: the routes of an internal
+// admin port. Codecs, validators, views and sql are shared with the server, while interceptors, response
+// wrappers and error handling are configured on the set
+func (s *RestServer) RouteSet(name string) *RestServer {
+	if set, has := s.routeSets[name]; has {
+		return set
+	}
+	if s.routeSets == nil {
+		s.routeSets = map[string]*RestServer{}
+	}
+	set := &RestServer{controller: &RestController{responseWrapper: list.New(), codecs: s.controller.codecs,
+		errorRenderer: ProblemRenderer{}, views: s.controller.views, sql: s.controller.sql,
+		bodyLimit: s.controller.bodyLimit, stopping: s.controller.stopping},
+		config: s.config, validator: s.validator, lifecycle: s.lifecycle}
+	s.routeSets[name] = set
+	return set
+}
+
+// all the listeners of the server, the address of the server comes first if it is set
+func (s *RestServer) listenerConfigs() []ListenerConfig {
+	var listeners []ListenerConfig
+	if s.address != "" {
+		listeners = append(listeners, ListenerConfig{Address: s.address, TLS: s.tlsEnabled()})
+	}
+	if s.config != nil {
+		listeners = append(listeners, s.config.Listeners...)
+	}
+	return append(listeners, s.listeners...)
+}
+
+func (s *RestServer) tlsEnabled() bool {
+	return s.config != nil && s.config.TLS.enabled()
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/azzill/goze/common"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, e := client.Get(url)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestListeners(t *testing.T) {
+	// paths of unix sockets are limited to about 100 bytes
+	dir, e := os.MkdirTemp("", "goze")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "goze.sock")
+
+	addr, adminAddr := freeAddr(t), freeAddr(t)
+	s := NewRestServer(addr, &HttpConfig{Listeners: []ListenerConfig{{Network: NetworkUnix, Address: socket, Mode: 0660}}})
+	s.AddListener(ListenerConfig{Address: adminAddr, RouteSet: "admin"})
+	s.GET("/hello", func(ctx *common.RequestCtx) interface{} {
+		return "hello"
+	})
+	s.RouteSet("admin").GET("/health", func(ctx *common.RequestCtx) interface{} {
+		return "up"
+	})
+	if s.RouteSet("admin") != s.RouteSet("admin") {
+		t.Error("route set is created again")
+	}
+	s.StartServerAsync()
+	waitListening(t, addr)
+	waitListening(t, adminAddr)
+
+	client := http.DefaultClient
+	if status, body := get(t, client, "http://"+addr+"/hello"); status != http.StatusOK || body != "hello" {
+		t.Error("unexpected response", status, body)
+	}
+	if status, _ := get(t, client, "http://"+addr+"/health"); status != http.StatusNotFound {
+		t.Error("admin route is served on the public listener", status)
+	}
+	if status, body := get(t, client, "http://"+adminAddr+"/health"); status != http.StatusOK || body != "up" {
+		t.Error("unexpected response", status, body)
+	}
+	if status, _ := get(t, client, "http://"+adminAddr+"/hello"); status != http.StatusNotFound {
+		t.Error("public route is served on the admin listener", status)
+	}
+
+	stat, e := os.Stat(socket)
+	if e != nil || stat.Mode().Perm() != 0660 {
+		t.Error("unexpected socket file", stat, e)
+	}
+	unixClient := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, NetworkUnix, socket)
+	}}}
+	if status, body := get(t, unixClient, "http://goze/hello"); status != http.StatusOK || body != "hello" {
+		t.Error("unexpected response", status, body)
+	}
+
+	s.Lifecycle().Shutdown()
+	if _, e := os.Stat(socket); !os.IsNotExist(e) {
+		t.Error("socket file is not removed", e)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, e := os.MkdirTemp("", "goze")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "stale.sock")
+
+	// a socket file left by a crashed process
+	stale, e := net.Listen(NetworkUnix, socket)
+	if e != nil {
+		t.Fatal(e)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, e := listenUnix(socket, 0)
+	if e != nil {
+		t.Fatal("stale socket is not removed", e)
+	}
+	defer listener.Close()
+	if _, e := listenUnix(socket, 0); e == nil {
+		t.Error("socket in use is removed")
+	}
+}
+
+func TestActivatedSockets(t *testing.T) {
+	tcp, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer tcp.Close()
+	f, e := tcp.(*net.TCPListener).File()
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+
+	sockets, e := fileSockets(int(f.Fd()), 1, []string{"web"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(sockets) != 1 || sockets[0].name != "web" || sockets[0].listener.Addr().String() != tcp.Addr().String() {
+		t.Fatal("unexpected sockets", sockets)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		_, _ = wr.Write([]byte("activated"))
+	})}
+	go func() {
+		_ = server.Serve(sockets[0].listener)
+	}()
+	defer server.Close()
+	if status, body := get(t, http.DefaultClient, "http://"+tcp.Addr().String()); status != http.StatusOK || body != "activated" {
+		t.Error("unexpected response", status, body)
+	}
+
+	// sockets passed to another process are ignored
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if sockets, e := activatedSockets(); e != nil || len(sockets) != 0 {
+		t.Error("sockets of another process are taken", sockets, e)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("environment is not cleared")
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "x")
+	if _, e := activatedSockets(); e == nil {
+		t.Error("invalid LISTEN_FDS is accepted")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"net/http"
+)
+
+// Response returned by handlers to respond with an explicit status, headers and cookies,
+// the Body is passed to the response wrappers the same way as a plain return value
+type Response struct {
+	Status  int
+	Header  http.Header
+	Cookies []*http.Cookie
+	Body    interface{}
+}
+
+func NewResponse(status int, body interface{}) *Response {
+	return &Response{Status: status, Header: http.Header{}, Body: body}
+}
+
+// 201 Created with the Location header
+func Created(location string, body interface{}) *Response {
+	return NewResponse(http.StatusCreated, body).SetHeader("Location", location)
+}
+
+func Accepted(body interface{}) *Response {
+	return NewResponse(http.StatusAccepted, body)
+}
+
+func NoContent() *Response {
+	return NewResponse(http.StatusNoContent, nil)
+}
+
+// redirect to the url with a 3xx status
+func Redirect(status int, url string) *Response {
+	return NewResponse(status, nil).SetHeader("Location", url)
+}
+
+func (r *Response) SetHeader(key string, value string) *Response {
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Set(key, value)
+	return r
+}
+
+func (r *Response) AddHeader(key string, value string) *Response {
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Add(key, value)
+	return r
+}
+
+func (r *Response) SetCookie(cookie *http.Cookie) *Response {
+	r.Cookies = append(r.Cookies, cookie)
+	return r
+}
+
+// the *Response returned by a handler, nil for other values
+func asResponse(v interface{}) *Response {
+	switch r := v.(type) {
+	case *Response:
+		return r
+	case Response:
+		return &r
+	}
+	return nil
+}
+
+// apply the headers and cookies, the status is written before the first write of the body
+// unless it is written explicitly, eg: by the error renderer
+func (r *Response) writer(wr http.ResponseWriter) *responseWriter {
+	for k, values := range r.Header {
+		for _, v := range values {
+			wr.Header().Add(k, v)
+		}
+	}
+	for _, cookie := range r.Cookies {
+		http.SetCookie(wr, cookie)
+	}
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &responseWriter{ResponseWriter: wr, status: status}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(w.status)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// write the pending status if nothing is written, eg: the body is nil
+func (w *responseWriter) flushHeader() {
+	if !w.wroteHeader {
+		w.WriteHeader(w.status)
+	}
+}
+
+// streamed bodies are flushed through
+func (w *responseWriter) Flush() {
+	w.flushHeader()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/midware"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type upperWrapper struct{}
+
+func (upperWrapper) Wrap(v interface{}, wr http.ResponseWriter) bool {
+	if s, ok := v.(string); ok {
+		_, _ = fmt.Fprint(wr, strings.ToUpper(s))
+		return true
+	}
+	return false
+}
+
+type panicBody struct{}
+
+type panicWrapper struct{}
+
+func (panicWrapper) Wrap(v interface{}, wr http.ResponseWriter) bool {
+	if _, ok := v.(panicBody); ok {
+		panic("wrapper failed")
+	}
+	return false
+}
+
+func TestResponse(t *testing.T) {
+	s := NewRestServer(":8080", nil)
+	s.AddResponseWrapper(upperWrapper{})
+
+	type user struct {
+		Id   int64  `path:"id" json:"id"`
+		Name string `json:"name"`
+	}
+	s.POST("/users/{id:int}", func(ctx *common.RequestCtx, in user) (*Response, error) {
+		return Created(fmt.Sprintf("/users/%d", in.Id), in), nil
+	})
+	s.DELETE("/users/{id:int}", func(ctx *common.RequestCtx) interface{} {
+		return NoContent().SetCookie(&http.Cookie{Name: "deleted", Value: ctx.PathVariable["id"]})
+	})
+	s.GET("/teapot", func(ctx *common.RequestCtx) interface{} {
+		return NewResponse(http.StatusTeapot, "short and stout").SetHeader("X-Pot", "tea")
+	})
+	s.GET("/failed", func(ctx *common.RequestCtx) interface{} {
+		return NewResponse(http.StatusAccepted, Conflict("taken"))
+	})
+
+	wr := serve(s, "POST", "/users/3", nil)
+	if wr.Code != http.StatusCreated || wr.Header().Get("Location") != "/users/3" ||
+		!strings.Contains(wr.Body.String(), `"id":3`) {
+		t.Error("unexpected created response", wr.Code, wr.Header(), wr.Body.String())
+	}
+
+	wr = serve(s, "DELETE", "/users/3", nil)
+	if wr.Code != http.StatusNoContent || wr.Body.Len() != 0 || !strings.HasPrefix(wr.Header().Get("Set-Cookie"), "deleted=3") {
+		t.Error("unexpected no content response", wr.Code, wr.Header(), wr.Body.String())
+	}
+
+	// the body goes through the response wrappers
+	wr = serve(s, "GET", "/teapot", nil)
+	if wr.Code != http.StatusTeapot || wr.Header().Get("X-Pot") != "tea" || wr.Body.String() != "SHORT AND STOUT" {
+		t.Error("unexpected wrapped response", wr.Code, wr.Header(), wr.Body.String())
+	}
+
+	// status of the error takes precedence
+	if wr := serve(s, "GET", "/failed", nil); wr.Code != http.StatusConflict {
+		t.Error("error body responded with", wr.Code)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	s := NewRestServer(":8080", nil)
+	s.AddResponseWrapper(upperWrapper{})
+	s.AddResponseWrapper(panicWrapper{})
+	s.AddAround(&midware.Compression{MinSize: 16})
+	large := strings.Repeat("goze ", 10)
+	s.GET("/text", func(ctx *common.RequestCtx) interface{} {
+		return Response{Status: http.StatusAccepted, Header: http.Header{"Content-Type": {"text/plain"}}, Body: large}
+	})
+	s.GET("/json", func(ctx *common.RequestCtx) interface{} {
+		return map[string]string{"name": large}
+	})
+	s.GET("/panic", func(ctx *common.RequestCtx) interface{} {
+		return panicBody{}
+	})
+	s.GET("/events", func(ctx *common.RequestCtx) interface{} {
+		return SSE(func(events *EventStream) error {
+			return events.Send(Event{Data: large})
+		})
+	})
+
+	gzipped := map[string]string{"Accept-Encoding": "gzip"}
+	read := func(wr io.Reader) string {
+		reader, e := gzip.NewReader(wr)
+		if e != nil {
+			t.Fatal(e)
+		}
+		b, _ := io.ReadAll(reader)
+		return string(b)
+	}
+	// custom wrappers write to the compressed writer
+	wr := serve(s, http.MethodGet, "/text", gzipped)
+	if wr.Code != http.StatusAccepted || wr.Header().Get("Content-Encoding") != "gzip" || read(wr.Body) != strings.ToUpper(large) {
+		t.Error("unexpected response", wr.Code, wr.Header())
+	}
+	wr = serve(s, http.MethodGet, "/json", gzipped)
+	if wr.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(read(wr.Body), large) {
+		t.Error("unexpected response", wr.Code, wr.Header())
+	}
+	wr = serve(s, http.MethodGet, "/events", gzipped)
+	if wr.Header().Get("Content-Encoding") != "" || !strings.Contains(wr.Body.String(), "data: "+large) {
+		t.Error("event stream is compressed", wr.Header(), wr.Body.String())
+	}
+	if wr := serve(s, http.MethodGet, "/json", nil); wr.Header().Get("Content-Encoding") != "" || !strings.Contains(wr.Body.String(), large) {
+		t.Error("unexpected response", wr.Header())
+	}
+	// a panic of a wrapper is written to the connection, not to the closed compress writer
+	wr = serve(s, http.MethodGet, "/panic", gzipped)
+	if wr.Code != http.StatusInternalServerError || wr.Header().Get("Content-Encoding") != "" ||
+		!strings.Contains(wr.Body.String(), "wrapper failed") {
+		t.Error("unexpected panic response", wr.Code, wr.Header(), wr.Body.String())
+	}
+}
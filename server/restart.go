@@ -0,0 +1,199 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// listeners passed to the new process on a hot restart, separated by semicolons
+	envListeners = "GOZE_LISTENERS"
+	// the pipe the new process writes to once it serves the listeners
+	envReadyFd = "GOZE_READY_FD"
+)
+
+const DefaultRestartTimeout = 30 * time.Second
+
+// max time to wait for the requests of the connections accepted before a handoff
+const handoffTimeout = time.Second
+
+var inheritance = &socketSet{load: inheritedSockets}
+
+type servedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// the listener inherited from the parent process on a hot restart, otherwise a new one
+func (s *RestServer) listen(l ListenerConfig) (net.Listener, error) {
+	listener, e := inheritance.take(l.String())
+	if e != nil {
+		return nil, e
+	}
+	if listener == nil {
+		if listener, e = l.listen(); e != nil {
+			return nil, e
+		}
+	} else if unix, ok := listener.(*net.UnixListener); ok {
+		// the socket file is removed by the last process serving it
+		unix.SetUnlinkOnClose(true)
+	}
+	s.served = append(s.served, servedListener{name: l.String(), listener: listener})
+	return listener, nil
+}
+
+// the listeners are inherited as the file descriptors from 3 on, in the order of their names
+func inheritedSockets() ([]*passedSocket, error) {
+	names := os.Getenv(envListeners)
+	_ = os.Unsetenv(envListeners)
+	if names == "" {
+		return nil, nil
+	}
+	list := strings.Split(names, ";")
+	return fileSockets(listenFdsStart, len(list), list)
+}
+
+// tell the parent process that the listeners are served, so that it starts draining
+func notifyReady() {
+	fd := os.Getenv(envReadyFd)
+	_ = os.Unsetenv(envReadyFd)
+	if fd == "" {
+		return
+	}
+	n, e := strconv.Atoi(fd)
+	if e != nil {
+		logger.Warn("Invalid", envReadyFd, fd)
+		return
+	}
+	pipe := os.NewFile(uintptr(n), "ready")
+	defer pipe.Close()
+	if _, e := pipe.Write([]byte{1}); e != nil {
+		logger.Warn("Failed to notify the parent process -", e.Error())
+	}
+}
+
+func (s *RestServer) restartTimeout() time.Duration {
+	if s.config == nil || s.config.RestartTimeout <= 0 {
+		return DefaultRestartTimeout
+	}
+	return s.config.RestartTimeout
+}
+
+// start the same binary (os.Args) with the listeners, so that a replaced binary is started,
+// and wait until it serves them. The new process is killed if it is not ready in time
+func (s *RestServer) restart() error {
+	// stdin, stdout and stderr are shared with the new process
+	fds := []uintptr{0, 1, 2}
+	names := make([]string, 0, len(s.served))
+	for _, served := range s.served {
+		conn, ok := served.listener.(syscall.Conn)
+		if !ok {
+			return fmt.Errorf("listener %s can not be passed", served.name)
+		}
+		raw, e := conn.SyscallConn()
+		if e != nil {
+			return fmt.Errorf("failed to pass listener %s: %v", served.name, e)
+		}
+		// the descriptors are passed as is, an os.File of the socket would switch it to blocking
+		// mode on exec, which blocks the Accept of this process. They stay open until the new
+		// process is started since the listeners are closed only after it
+		if e := raw.Control(func(fd uintptr) { fds = append(fds, fd) }); e != nil {
+			return fmt.Errorf("failed to pass listener %s: %v", served.name, e)
+		}
+		names = append(names, served.name)
+	}
+	ready, readyWriter, e := os.Pipe()
+	if e != nil {
+		return e
+	}
+	defer ready.Close()
+
+	path, e := exec.LookPath(os.Args[0])
+	if e != nil {
+		_ = readyWriter.Close()
+		return fmt.Errorf("failed to find the binary: %v", e)
+	}
+	env := append(os.Environ(), envListeners+"="+strings.Join(names, ";"),
+		envReadyFd+"="+strconv.Itoa(len(fds)))
+	pid, _, e := syscall.StartProcess(path, os.Args, &syscall.ProcAttr{Env: env,
+		Files: append(fds, readyWriter.Fd())})
+	// the read end gets EOF if the new process exits before it is ready
+	_ = readyWriter.Close()
+	if e != nil {
+		return fmt.Errorf("failed to start the new process: %v", e)
+	}
+	process, e := os.FindProcess(pid)
+	if e != nil {
+		return e
+	}
+	go func() {
+		_, _ = process.Wait()
+	}()
+
+	result := make(chan error, 1)
+	go func() {
+		if _, e := ready.Read(make([]byte, 1)); e != nil {
+			result <- errors.New("new process exited before it is ready")
+			return
+		}
+		result <- nil
+	}()
+	timeout := s.restartTimeout()
+	select {
+	case e := <-result:
+		if e != nil {
+			return e
+		}
+	case <-time.After(timeout):
+		_ = process.Kill()
+		return fmt.Errorf("new process is not ready in %s", timeout)
+	}
+
+	// new connections go to the new process, the socket files are kept for it
+	for _, served := range s.served {
+		if unix, ok := served.listener.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+		_ = served.listener.Close()
+	}
+	logger.Info("New process", pid, "serves the listeners")
+	s.awaitAccepted(handoffTimeout)
+	return nil
+}
+
+func (s *RestServer) trackConn(conn net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		s.accepted.Store(conn, struct{}{})
+	} else {
+		s.accepted.Delete(conn)
+	}
+}
+
+// requests read after the shutdown starts are dropped by http.Server, so the connections accepted
+// right before the handoff are given time to send their requests
+func (s *RestServer) awaitAccepted(timeout time.Duration) {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		pending := false
+		s.accepted.Range(func(key, value interface{}) bool {
+			pending = true
+			return false
+		})
+		if !pending {
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+//go:build !windows
+
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"github.com/azzill/goze/common"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// address served by the processes of TestRestartProcess
+const envRestartAddr = "GOZE_TEST_RESTART_ADDR"
+
+// the server restarted by TestRestart, the restarted process runs the same test
+func TestRestartProcess(t *testing.T) {
+	addr := os.Getenv(envRestartAddr)
+	if addr == "" {
+		t.Skip("run by TestRestart")
+	}
+	s := NewRestServer(addr, nil)
+	s.GET("/pid", func(ctx *common.RequestCtx) interface{} {
+		return strconv.Itoa(os.Getpid())
+	})
+	s.GET("/slow", func(ctx *common.RequestCtx) interface{} {
+		time.Sleep(300 * time.Millisecond)
+		return strconv.Itoa(os.Getpid())
+	})
+	s.StartServer()
+}
+
+func TestRestart(t *testing.T) {
+	addr := freeAddr(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRestartProcess$")
+	cmd.Env = append(os.Environ(), envRestartAddr+"="+addr)
+	if e := cmd.Start(); e != nil {
+		t.Fatal(e)
+	}
+	defer cmd.Process.Kill()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	waitListening(t, addr)
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	pid := func() (string, error) {
+		resp, e := client.Get("http://" + addr + "/pid")
+		if e != nil {
+			return "", e
+		}
+		defer resp.Body.Close()
+		b, e := ioutil.ReadAll(resp.Body)
+		return string(b), e
+	}
+	parent, e := pid()
+	if e != nil || parent != strconv.Itoa(cmd.Process.Pid) {
+		t.Fatal("unexpected pid", parent, e)
+	}
+
+	// a request in flight when the restart starts
+	slow := make(chan string, 1)
+	go func() {
+		resp, e := client.Get("http://" + addr + "/slow")
+		if e != nil {
+			slow <- e.Error()
+			return
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		slow <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if e := cmd.Process.Signal(syscall.SIGUSR2); e != nil {
+		t.Fatal(e)
+	}
+
+	// no request fails during the handoff
+	var child string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		p, e := pid()
+		if e != nil {
+			t.Fatal("request failed during the restart", e)
+		}
+		if p != parent {
+			child = p
+			break
+		}
+	}
+	if child == "" {
+		t.Fatal("new process is not serving")
+	}
+	childPid, _ := strconv.Atoi(child)
+	defer syscall.Kill(childPid, syscall.SIGTERM)
+
+	if b := <-slow; b != parent {
+		t.Error("request in flight is not drained", b)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Error("parent process does not exit")
+	}
+	if p, e := pid(); e != nil || p != child {
+		t.Error("unexpected pid after the restart", p, e, parent, child)
+	}
+}
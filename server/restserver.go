@@ -8,21 +8,22 @@ package server
 
 import (
 	"container/list"
-	"context"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/azzill/goze/codec"
 	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/lifecycle"
 	"github.com/azzill/goze/log"
 	"github.com/azzill/goze/midware"
 	"github.com/azzill/goze/sql"
 	"html/template"
+	"io"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"regexp"
 	"runtime"
-	"strings"
-	"syscall"
+	"sync"
 	"time"
 )
 
@@ -33,15 +34,40 @@ type HttpConfig struct {
 	ReadHeaderTimeout time.Duration
 	WriteTimeout      time.Duration
 	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// max time to wait for the active requests when shutting down
+	DrainTimeout time.Duration
+	TLS          TLSConfig
+	// served besides the address of the server
+	Listeners []ListenerConfig
+	// max time to wait for the new process to serve the listeners on a hot restart
+	RestartTimeout time.Duration
+	// max bytes of request bodies, responded as 413 if exceeded, unlimited if zero
+	MaxBodyBytes int64
 }
 type RestServer struct {
 	controller *RestController
 	config     *HttpConfig
 	address    string
+	validator  *Validator
+	lifecycle  *lifecycle.Manager
+	listeners  []ListenerConfig
+	routeSets  map[string]*RestServer
+	served     []servedListener
+	// connections accepted but not read yet
+	accepted sync.Map
 }
 
 func NewRestServer(address string, config *HttpConfig) *RestServer {
-	return &RestServer{controller: &RestController{responseWrapper: list.New()}, config: config, address: address}
+	var drainTimeout time.Duration
+	if config != nil {
+		drainTimeout = config.DrainTimeout
+	}
+	manager := lifecycle.NewManager(drainTimeout)
+	return &RestServer{controller: &RestController{responseWrapper: list.New(), codecs: codec.NewDefaultRegistry(),
+		errorRenderer: ProblemRenderer{}, bodyLimit: config.maxBodyBytes(), stopping: manager.Stopping()},
+		config: config, address: address,
+		validator: NewValidator(), lifecycle: manager}
 }
 
 // customize response by handle it manually return true if handled
@@ -50,10 +76,21 @@ type ResponseWrapper interface {
 }
 
 type RestController struct {
-	mapping            map[RequestMethod]*prefixNode
+	root               *prefixNode
+	conflicts          []RouteConflict
+	names              map[string]*route
+	routes             []*route
+	codecs             *codec.Registry
+	errorMappers       []ErrorMapper
+	errorRenderer      ErrorRenderer
+	views              *ViewEngine
 	requestInterceptor midware.InterceptorChain
+	arounds            midware.AroundChain
 	responseWrapper    *list.List
 	sql                *sql.SQL
+	bodyLimit          int64
+	// closed when the server shuts down, long-lived event streams end on it
+	stopping <-chan struct{}
 }
 
 type RequestMethod string
@@ -67,6 +104,9 @@ const (
 	Delete  RequestMethod = "DELETE"
 	Head    RequestMethod = "HEAD"
 	Options RequestMethod = "OPTIONS"
+	Patch   RequestMethod = "PATCH"
+	Trace   RequestMethod = "TRACE"
+	Connect RequestMethod = "CONNECT"
 )
 
 type RequestMapping struct {
@@ -76,202 +116,169 @@ type RequestMapping struct {
 	handler RequestHandler
 }
 
-type prefixNode struct {
-	mapped      bool
-	matchAll    bool
-	prefix      string
-	placeholder string
-	handler     RequestHandler
-	parent      *prefixNode
-	children    map[string]*prefixNode
+func (s *RestServer) GET(pattern string, handler interface{}, options ...RouteOption) *RestServer {
+	return s.Mapping(Get, pattern, handler, options...)
 }
-
-var urlFormatRegexp []*regexp.Regexp
-
-func init() {
-	r1, _ := regexp.Compile("\\.+/")
-	r2, _ := regexp.Compile("/{2,}")
-	urlFormatRegexp = []*regexp.Regexp{r1, r2}
+func (s *RestServer) POST(pattern string, handler interface{}, options ...RouteOption) *RestServer {
+	return s.Mapping(Post, pattern, handler, options...)
 }
-
-func (s *RestServer) GET(pattern string, handler RequestHandler) *RestServer {
-	return s.Mapping(Get, pattern, handler)
+func (s *RestServer) DELETE(pattern string, handler interface{}, options ...RouteOption) *RestServer {
+	return s.Mapping(Delete, pattern, handler, options...)
 }
-func (s *RestServer) POST(pattern string, handler RequestHandler) *RestServer {
-	return s.Mapping(Post, pattern, handler)
+func (s *RestServer) PUT(pattern string, handler interface{}, options ...RouteOption) *RestServer {
+	return s.Mapping(Put, pattern, handler, options...)
 }
-func (s *RestServer) DELETE(pattern string, handler RequestHandler) *RestServer {
-	return s.Mapping(Delete, pattern, handler)
+func (s *RestServer) PATCH(pattern string, handler interface{}, options ...RouteOption) *RestServer {
+	return s.Mapping(Patch, pattern, handler, options...)
 }
-func (s *RestServer) PUT(pattern string, handler RequestHandler) *RestServer {
-	return s.Mapping(Put, pattern, handler)
+
+// any method is accepted, HEAD and OPTIONS are answered automatically
+// for the patterns that are not mapped with them explicitly
+func (s *RestServer) Mapping(method RequestMethod, pattern string, handler interface{}, options ...RouteOption) *RestServer {
+	s.mapping(method, pattern, handler, nil, options)
+	return s
 }
 
-func (s *RestServer) Mapping(method RequestMethod, pattern string, handler RequestHandler) *RestServer {
+// register a custom validation rule used by the `validate` tags of handler inputs,
+// it must be registered before the routes using it are mapped
+func (s *RestServer) AddValidator(name string, rule ValidatorFunc) {
+	s.validator.AddRule(name, rule)
+}
 
-	if s.controller.mapping == nil {
-		s.controller.mapping = map[RequestMethod]*prefixNode{
-			Get:     {prefix: "", children: map[string]*prefixNode{}},
-			Put:     {prefix: "", children: map[string]*prefixNode{}},
-			Post:    {prefix: "", children: map[string]*prefixNode{}},
-			Delete:  {prefix: "", children: map[string]*prefixNode{}},
-			Head:    {prefix: "", children: map[string]*prefixNode{}},
-			Options: {prefix: "", children: map[string]*prefixNode{}}}
+// codecs decoding request bodies and encoding responses by media type,
+// JSON, XML, form-urlencoded, multipart and MessagePack are registered by default
+func (s *RestServer) Codecs() *codec.Registry {
+	return s.controller.codecs
+}
 
+func (c *RestServer) WithSQL(sql *sql.SQL) {
+	c.controller.sql = sql
+	for _, set := range c.routeSets {
+		set.WithSQL(sql)
 	}
-	// format pattern
-	pattern = strings.TrimSpace(pattern)
-	if len(pattern) == 0 {
-		panic("empty pattern")
-	}
-	if pattern[0] == '/' {
-		if len(pattern) == 1 {
-			pattern = ""
-		} else {
-			pattern = pattern[1:]
-		}
-	}
-	prefixes := strings.Split(pattern, "/")
-	currentNode := s.controller.mapping[method]
-	for i := 0; pattern != "" && i < len(prefixes); i++ {
-		prefix := prefixes[i]
-		placeholder := ""
+}
 
-		//node already match all patterns
-		if currentNode.matchAll {
-			panic("ambiguous mapping")
-		}
+func (c *RestController) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	var obj interface{} = nil
 
-		if len(prefix) > 0 && prefix[0] == ':' {
-			placeholder = prefix[1:]
-			prefix = "*"
-		} else if len(prefix) > 0 && prefix[0] == '*' {
-			placeholder = prefix[1:]
-			prefix = "*"
-			if i < len(prefix)-1 {
-				logger.Error(pattern, "full pattern placeholder must be the end")
-				return s
-			}
+	//recover from any exception, written to the writer of the connection since
+	//the writers swapped in later may be closed by then
+	origin := wr
+	defer func() {
+		if err := recover(); err != nil {
+			HttpError(origin, http.StatusInternalServerError, fmt.Sprint(err), true)
+		}
+	}()
 
-			if currentNode.children[prefix] != nil {
-				logger.Error(pattern, "ambiguous mapping")
-				return s
-			}
+	node, values := c.match(RequestMethod(r.Method), r.URL.Path)
 
-			nextNode := &prefixNode{prefix: prefix, placeholder: placeholder,
-				mapped: false, children: nil, matchAll: true}
-			currentNode.children[prefix] = nextNode
-			currentNode = nextNode
-			break
+	//unmapped
+	if node == nil {
+		c.renderError(wr, r, NotFound(r.URL.Path+" is not mapped"))
+		return
+	}
 
+	//mapped under other methods only, OPTIONS is answered automatically
+	rt := node.route(RequestMethod(r.Method))
+	if rt == nil {
+		nodes := c.candidates(r.URL.Path)
+		allow := allowOf(nodes)
+		if RequestMethod(r.Method) != Options {
+			wr.Header().Set("Allow", allow)
+			c.renderError(wr, r, NewHTTPError(http.StatusMethodNotAllowed, r.Method+" is not allowed"))
+			return
 		}
+		method := RequestMethod(r.Header.Get("Access-Control-Request-Method"))
+		rt = optionsRoute(allow, preflightRoute(nodes, method))
+	}
 
-		nextNode := currentNode.children[prefix]
-
-		//allocate children node
-		if nextNode == nil {
-			nextNode = &prefixNode{prefix: prefix, placeholder: placeholder,
-				mapped: false, children: map[string]*prefixNode{}, matchAll: false}
-			currentNode.children[prefix] = nextNode
+	// refused early if the declared length is too large, otherwise reading fails at the limit
+	if limit := c.bodyLimitOf(rt); limit > 0 {
+		if r.ContentLength > limit {
+			c.renderError(wr, r, bodyTooLarge(limit, nil))
+			return
 		}
-		currentNode = nextNode
+		r.Body = http.MaxBytesReader(wr, r.Body, limit)
 	}
 
-	if currentNode.mapped {
-		logger.Error(pattern, "ambiguous mapping")
+	//Begin sql transaction
+	ctx := common.NewRequestCtx(r.URL.Query(), rt.pathVariables(values), r, r.MultipartForm, wr, c.sql)
+	ctx.Codecs = c.codecs
+	ctx.Metadata = rt.metadata
+	// the hooks of the arounds run even if a later step panics, eg: closing the compress writer
+	defer ctx.Complete()
+
+	// the arounds of the server enclose the ones of the group, which enclose the ones of the route,
+	// the handler is the innermost step
+	next := midware.Next(func(ctx *common.RequestCtx) interface{} {
+		return c.handle(ctx, rt)
+	})
+	next = midware.Wrap(next, rt.arounds...)
+	if rt.group != nil {
+		next = rt.group.wrapArounds(next)
 	}
+	obj = c.arounds.Wrap(next)(ctx)
 
-	currentNode.mapped = true
-	currentNode.handler = handler
-	logger.Info("URL Mapped", method, "/"+pattern)
-	return s
-}
+	// written to the writer the arounds may have swapped in, eg: compression
+	wr = ctx.ResponseWriter
 
-func (c *RestServer) WithSQL(sql *sql.SQL) {
-	c.controller.sql = sql
-}
-
-func (c *RestController) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
-	var obj interface{} = nil
-	pv := make(map[string]string)
-	url := r.URL.Path
-
-	for _, reg := range urlFormatRegexp {
-		url = reg.ReplaceAllString(url, "/")
+	// explicit status, headers and cookies, the body goes on to the wrappers
+	var rw *responseWriter
+	if resp := asResponse(obj); resp != nil {
+		rw = resp.writer(wr)
+		wr, obj = rw, resp.Body
 	}
 
-	println("Formatted: ", url)
-
-	split := strings.Split(url[1:], "/")
-	currentNode := c.mapping[RequestMethod(r.Method)]
+	c.wrapResponse(obj, wr, r)
 
-	for i := 0; url[1:] != "" && i < len(split); i++ {
-		s := split[i]
-		node := currentNode.children[s]
-
-		if node == nil {
-			node = currentNode.children["*"]
-			if node == nil {
-				currentNode = nil
-				break
-			}
-			if node.matchAll {
-				pv[node.placeholder] = strings.Join(split[i:], "/")
-				currentNode = node
-				break
-			} else {
-				pv[node.placeholder] = s
-			}
-		}
-		currentNode = node
+	// the status of a Response without body
+	if rw != nil {
+		rw.flushHeader()
 	}
+}
 
-	//recover from any exception
-	defer func() {
-		if err := recover(); err != nil {
-			HttpError(wr, http.StatusInternalServerError, fmt.Sprint(err), true)
-		}
-	}()
+// interceptors, handler and the end of the sql transaction, the result is written by the caller
+func (c *RestController) handle(ctx *common.RequestCtx, rt *route) interface{} {
+	// firstly, handle with interceptor
+	intercepted, obj := c.requestInterceptor.CallInterceptors(ctx)
 
-	//mapped
-	if currentNode != nil && currentNode.mapped {
+	// then the interceptors of the group the route belongs to
+	if !intercepted && rt.group != nil {
+		intercepted, obj = rt.group.callInterceptors(ctx)
+	}
 
-		//Begin sql transaction
-		ctx := common.NewRequestCtx(r.URL.Query(), pv, r, r.MultipartForm, wr, c.sql)
+	if !intercepted {
+		obj = rt.handler(ctx)
+	}
 
-		var intercepted bool
-		// firstly, handle with interceptor
-		intercepted, obj = c.requestInterceptor.CallInterceptors(ctx)
+	body := obj
+	if resp := asResponse(obj); resp != nil {
+		body = resp.Body
+	}
 
-		if !intercepted {
-			obj = currentNode.handler(ctx)
+	//returned value is not an error commit sql transaction
+	if _, ok := body.(error); !ok {
+		if e := ctx.Tx.Commit(); e != nil {
+			return e
 		}
-
-		//returned value is not an error commit sql transaction
-		if _, ok := obj.(error); !ok {
-			if e := ctx.Tx.Commit(); e != nil {
-				obj = e
-			}
-		} else {
-			if e := ctx.Tx.Rollback(); e != nil {
-				obj = e
-			}
+	} else {
+		if e := ctx.Tx.Rollback(); e != nil {
+			return e
 		}
+	}
+	return obj
+}
 
-		//find a proper response wrapper
-		for e := c.responseWrapper.Front(); e != nil; e = e.Next() {
-			if e.Value.(ResponseWrapper).Wrap(obj, wr) {
-				return
-			}
+func (c *RestController) wrapResponse(obj interface{}, wr http.ResponseWriter, r *http.Request) {
+	//find a proper response wrapper
+	for e := c.responseWrapper.Front(); e != nil; e = e.Next() {
+		if e.Value.(ResponseWrapper).Wrap(obj, wr) {
+			return
 		}
-
-		//default wrapper
-		c.defaultResponseWrapper(obj, wr)
-	} else { //unmapped
-		http.NotFound(wr, r)
-		return
 	}
 
+	//default wrapper
+	c.defaultResponseWrapper(obj, wr, r)
 }
 
 // append to the top of response
@@ -280,7 +287,7 @@ func (s *RestServer) AddResponseWrapper(wrapper ResponseWrapper) {
 }
 
 //default response wrapper
-func (c *RestController) defaultResponseWrapper(v interface{}, wr http.ResponseWriter) bool {
+func (c *RestController) defaultResponseWrapper(v interface{}, wr http.ResponseWriter, r *http.Request) bool {
 	var err error
 
 	//Nil
@@ -288,22 +295,45 @@ func (c *RestController) defaultResponseWrapper(v interface{}, wr http.ResponseW
 		return true
 	}
 
-	//Unhandled error
+	//Unhandled error, rendered by the status it is mapped to
 	if e, ok := v.(error); ok {
-		HttpError(wr, http.StatusInternalServerError, e.Error(), false)
+		c.renderError(wr, r, e)
 		return true
 	}
 
-	switch (v).(type) {
+	switch t := (v).(type) {
 	case string:
 		wr.Header().Set("Content-Type", "text/plain")
-		_, err = wr.Write([]byte((v).(string))) //return origin value if string
+		_, err = wr.Write([]byte(t)) //return origin value if string
+	case *ViewResult:
+		page, e := c.renderView(t)
+		if e != nil {
+			c.renderError(wr, r, e)
+			return true
+		}
+		if wr.Header().Get("Content-Type") == "" {
+			wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		_, err = wr.Write(page)
+	case *os.File:
+		if e := serveFile(wr, r, t); e != nil {
+			c.renderError(wr, r, e)
+			return true
+		}
+	case io.Reader:
+		err = writeReader(wr, t)
+	case Stream:
+		err = t.write(wr)
+	case SSE:
+		err = t.serve(wr, r, c.stopping)
 	default:
-		wr.Header().Set("Content-Type", "application/json")
-		if bytes, err := json.Marshal(v); err != nil {
+		// encoded by the codec negotiated from the Accept header
+		if err = c.codecs.Encode(wr, r, v); errors.Is(err, codec.ErrNotAcceptable) {
+			wr.Header().Del("Content-Type")
+			c.renderError(wr, r, NewHTTPError(http.StatusNotAcceptable, r.Header.Get("Accept")+" is not acceptable"))
+			return true
+		} else if err != nil {
 			http.Error(wr, err.Error(), 500)
-		} else {
-			_, err = wr.Write(bytes)
 		}
 	}
 
@@ -324,28 +354,183 @@ func (s *RestServer) StartServerAsync() *http.Server {
 func (s *RestServer) AddInterceptor(interceptor midware.Interceptor) {
 	s.controller.requestInterceptor.AddInterceptor(interceptor)
 }
+
+// arounds wrap the interceptors and the handler of every route, the first added is the outermost
+func (s *RestServer) AddAround(around midware.Around) {
+	s.controller.arounds.AddAround(around)
+}
+
+// mappings rejected so far, the server refuses to start if there is any
+func (s *RestServer) Conflicts() []RouteConflict {
+	return s.controller.conflicts
+}
+
+func (s *RestServer) checkMapping() {
+	conflicts := s.Conflicts()
+	if len(conflicts) == 0 {
+		return
+	}
+	report := fmt.Sprintf("%d mapping conflict(s) found:", len(conflicts))
+	for _, c := range conflicts {
+		report += "\n\t" + c.Error()
+	}
+	panic(report)
+}
+
 func (s *RestServer) startWith(block bool) *http.Server {
+	s.checkMapping()
+	for _, set := range s.routeSets {
+		set.checkMapping()
+	}
 
-	server := &http.Server{Addr: s.address, Handler: s.controller}
+	// templates are precompiled unless they are reloaded on every render
+	if v := s.controller.views; v != nil && !v.config.Reload {
+		if e := v.Load(); e != nil {
+			panic("failed to load views: " + e.Error())
+		}
+	}
+
+	listeners := s.listenerConfigs()
+	if len(listeners) == 0 {
+		panic("no address or listener is configured")
+	}
+	var server *http.Server
+	for _, l := range listeners {
+		if started := s.serve(l); server == nil {
+			server = started
+		}
+	}
+
+	if s.tlsEnabled() && s.config.TLS.RedirectAddr != "" {
+		s.serveRedirect(server)
+	}
+
+	// the parent process drains once the listeners are served on a hot restart
+	notifyReady()
+	s.lifecycle.OnRestart(s.restart)
+
+	if !block {
+		return server
+	}
+
+	s.lifecycle.Wait()
+	return nil
+}
+
+// every listener is served by its own http.Server, so that it is drained with the others
+func (s *RestServer) serve(l ListenerConfig) *http.Server {
+	handler := s.controller
+	if l.RouteSet != "" {
+		set, has := s.routeSets[l.RouteSet]
+		if !has {
+			panic("route set `" + l.RouteSet + "` of " + l.String() + " is not found")
+		}
+		handler = set.controller
+	}
+	if l.TLS && !s.tlsEnabled() {
+		panic("tls is not configured for " + l.String())
+	}
+
+	listener, e := s.listen(l)
+	if e != nil {
+		panic("failed to listen on " + l.String() + ": " + e.Error())
+	}
+	server := s.httpServer()
+	server.Addr, server.Handler = listener.Addr().String(), handler
+	if !l.TLS {
+		server.TLSConfig, server.TLSNextProto = nil, nil
+	}
+	server.ConnState = s.trackConn
+	s.lifecycle.AddServer(server)
 
 	go func() {
-		logger.Info("Goze Server started at", s.address)
-		err := server.ListenAndServe()
-		logger.Warn(err)
+		var err error
+		if server.TLSConfig != nil {
+			logger.Info("Goze Server started at", l.String(), "(https)")
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			logger.Info("Goze Server started at", l.String())
+			err = server.Serve(listener)
+		}
+		// closed by a hot restart
+		if err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			logger.Warn(err)
+		}
+	}()
+	return server
+}
 
+// plain http requests are redirected to the address of the server
+func (s *RestServer) serveRedirect(server *http.Server) {
+	listener, e := s.listen(ListenerConfig{Address: s.config.TLS.RedirectAddr})
+	if e != nil {
+		logger.Warn("Failed to redirect http to https -", e.Error())
+		return
+	}
+	redirect := &http.Server{Addr: listener.Addr().String(), Handler: redirectHandler(s.address),
+		ReadHeaderTimeout: server.ReadHeaderTimeout, IdleTimeout: server.IdleTimeout}
+	s.lifecycle.AddServer(redirect)
+	go func() {
+		logger.Info("Redirecting http to https at", redirect.Addr)
+		if err := redirect.Serve(listener); err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			logger.Warn(err)
+		}
 	}()
+}
 
-	if !block {
+// limit of the route or the server, 0 if unlimited
+func (c *RestController) bodyLimitOf(rt *route) int64 {
+	if rt.bodyLimit < 0 {
+		return 0
+	}
+	if rt.bodyLimit > 0 {
+		return rt.bodyLimit
+	}
+	return c.bodyLimit
+}
+
+func (c *HttpConfig) maxBodyBytes() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.MaxBodyBytes
+}
+
+// the http.Server configured by HttpConfig
+func (s *RestServer) httpServer() *http.Server {
+	server := &http.Server{Addr: s.address, Handler: s.controller}
+	if s.config == nil {
 		return server
 	}
+	server.ReadTimeout = s.config.ReadTimeout
+	server.ReadHeaderTimeout = s.config.ReadHeaderTimeout
+	server.WriteTimeout = s.config.WriteTimeout
+	server.IdleTimeout = s.config.IdleTimeout
+	server.MaxHeaderBytes = s.config.MaxHeaderBytes
+
+	if s.config.TLS.enabled() {
+		config, e := s.config.TLS.build()
+		if e != nil {
+			panic("invalid tls configuration: " + e.Error())
+		}
+		server.TLSConfig = config
+		// an empty non-nil map disables HTTP/2
+		if s.config.TLS.DisableHTTP2 {
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	}
+	return server
+}
 
-	sig := make(chan os.Signal)
-	signal.Notify(sig, syscall.SIGINT)
-	<-sig
+// the lifecycle manager draining the server and running the shutdown hooks,
+// StartServer blocks until it shuts down on SIGINT or SIGTERM
+func (s *RestServer) Lifecycle() *lifecycle.Manager {
+	return s.lifecycle
+}
 
-	c, _ := context.WithCancel(context.Background())
-	_ = server.Shutdown(c)
-	return nil
+// register a hook run after the server is drained
+func (s *RestServer) OnShutdown(name string, hook lifecycle.Hook) {
+	s.lifecycle.OnShutdown(name, hook)
 }
 
 const errorPage = "<h1>%v %v</h1><h2>%v</h2><p>%v</p>"
@@ -9,8 +9,12 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/azzill/goze/codec"
 	"github.com/azzill/goze/common"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -59,3 +63,71 @@ func TestServer(t *testing.T) {
 	restServer.StartServer()
 
 }
+
+func TestBodyLimit(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{MaxBodyBytes: 16})
+	echo := func(ctx *common.RequestCtx) interface{} {
+		v := map[string]interface{}{}
+		if e := ctx.ParseBody(&v); e != nil {
+			return e
+		}
+		return v
+	}
+	s.POST("/echo", echo)
+	s.POST("/large", echo, BodyLimit(1024))
+	s.POST("/upload", func(ctx *common.RequestCtx) interface{} {
+		form, e := ctx.ParseForm()
+		if e != nil {
+			return e
+		}
+		return fmt.Sprint(form.Value["name"], len(form.File["file"]))
+	}, BodyLimit(0))
+	s.Codecs().Register(codec.Multipart{MaxFileSize: 8})
+
+	post := func(url string, body string, contentType string, chunked bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		if chunked {
+			r.ContentLength = -1
+		}
+		wr := httptest.NewRecorder()
+		s.controller.ServeHTTP(wr, r)
+		return wr
+	}
+	large := `{"name":"` + strings.Repeat("a", 32) + `"}`
+
+	if wr := post("/echo", `{"a":1}`, codec.MediaTypeJSON, false); wr.Code != http.StatusOK {
+		t.Error("small body is refused", wr.Code, wr.Body.String())
+	}
+	// refused by Content-Length and while reading
+	for _, chunked := range []bool{false, true} {
+		wr := post("/echo", large, codec.MediaTypeJSON, chunked)
+		problem := Problem{}
+		_ = json.Unmarshal(wr.Body.Bytes(), &problem)
+		if wr.Code != http.StatusRequestEntityTooLarge || problem.Code != "body_too_large" {
+			t.Error("large body is accepted", chunked, wr.Code, wr.Body.String())
+		}
+	}
+	if wr := post("/large", large, codec.MediaTypeJSON, true); wr.Code != http.StatusOK {
+		t.Error("limit of the route is not applied", wr.Code, wr.Body.String())
+	}
+
+	// multipart forms are parsed on demand with the limits of the codec
+	multipartBody := func(content string) (string, string) {
+		body := &strings.Builder{}
+		w := multipart.NewWriter(body)
+		_ = w.WriteField("name", strings.Repeat("n", 32))
+		fw, _ := w.CreateFormFile("file", "a.txt")
+		_, _ = fw.Write([]byte(content))
+		_ = w.Close()
+		return body.String(), w.FormDataContentType()
+	}
+	body, contentType := multipartBody("content")
+	if wr := post("/upload", body, contentType, true); wr.Code != http.StatusOK || wr.Body.String() != "["+strings.Repeat("n", 32)+"] 1" {
+		t.Error("unexpected form", wr.Code, wr.Body.String())
+	}
+	body, contentType = multipartBody("too large content")
+	if wr := post("/upload", body, contentType, true); wr.Code != http.StatusRequestEntityTooLarge {
+		t.Error("large file is accepted", wr.Code, wr.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// build the url of a named route, placeholders are replaced by params and
+// query is appended if it is not empty.
+// eg: a route mapped as s.GET("/users/{id:int}", show, server.Named("user.show")),
+// s.URLFor("user.show", map[string]string{"id": "42"}, nil) returns "/users/42"
+func (s *RestServer) URLFor(name string, params map[string]string, query url.Values) (string, error) {
+	r := s.controller.names[name]
+	if r == nil {
+		return "", fmt.Errorf("no route named `%s`", name)
+	}
+
+	segments := strings.Split(r.pattern[1:], "/")
+	for i, segment := range segments {
+		placeholder, constraint, ok, _ := parsePlaceholder(segment)
+		catchAll := !ok && len(segment) > 0 && segment[0] == '*'
+		if catchAll {
+			placeholder = segment[1:]
+		}
+		if !ok && !catchAll {
+			continue
+		}
+
+		value, has := params[placeholder]
+		if !has {
+			return "", fmt.Errorf("missing placeholder `%s` of route `%s`", placeholder, name)
+		}
+
+		if catchAll {
+			parts := strings.Split(value, "/")
+			for j := range parts {
+				parts[j] = url.PathEscape(parts[j])
+			}
+			segments[i] = strings.Join(parts, "/")
+			continue
+		}
+
+		if constraint != nil && !constraint.match(value) {
+			return "", fmt.Errorf("placeholder `%s` of route `%s` does not match %s", placeholder, name, constraint)
+		}
+		segments[i] = url.PathEscape(value)
+	}
+
+	u := "/" + strings.Join(segments, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
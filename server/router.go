@@ -0,0 +1,434 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"fmt"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/midware"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// a handler registered for a method on a node of the prefix tree
+type route struct {
+	method  RequestMethod
+	pattern string
+	handler RequestHandler
+	group   *RouteGroup
+	// names of the placeholders in the order they appear in the pattern
+	params      []string
+	name        string
+	handlerName string
+	// max bytes of the body, 0 for the limit of the server, negative for unlimited
+	bodyLimit int64
+	// run inside the arounds of the server and the groups
+	arounds []midware.Around
+	// read by interceptors through RequestCtx.Metadata, eg: the roles required by auth.RequireRole
+	metadata map[string]interface{}
+	// origin policy of a websocket handshake
+	checkOrigin func(r *http.Request) bool
+}
+
+// RouteOption customizes a mapping when it is registered
+type RouteOption func(r *route)
+
+// name the route so that its url can be built by RestServer.URLFor
+func Named(name string) RouteOption {
+	return func(r *route) {
+		r.name = name
+	}
+}
+
+// limit the body of the route instead of HttpConfig.MaxBodyBytes, eg: for uploads,
+// the body is unlimited if n <= 0
+func BodyLimit(n int64) RouteOption {
+	return func(r *route) {
+		if n <= 0 {
+			n = -1
+		}
+		r.bodyLimit = n
+	}
+}
+
+// attach metadata to the route, options of other packages are built on it
+func Meta(key string, value interface{}) RouteOption {
+	return func(r *route) {
+		if r.metadata == nil {
+			r.metadata = map[string]interface{}{}
+		}
+		r.metadata[key] = value
+	}
+}
+
+// wrap the route with arounds, eg: a rate limiter of the route
+func Arounds(arounds ...midware.Around) RouteOption {
+	midware.Prepare(arounds...)
+	return func(r *route) {
+		r.arounds = append(r.arounds, arounds...)
+	}
+}
+
+// all the methods share one prefix tree, so that a path mapped under another
+// method can be told apart from an unmapped one.
+// A segment is matched by the static children first, then by the placeholders
+// and the catch-all at last, the matcher backtracks if a deeper segment fails
+type prefixNode struct {
+	prefix     string
+	constraint *pathConstraint
+	routes     map[RequestMethod]*route
+	parent     *prefixNode
+	children   map[string]*prefixNode
+	// placeholder children, constrained ones are tried before the others
+	params   []*prefixNode
+	catchAll *prefixNode
+}
+
+// RouteConflict describes a mapping that is rejected because it is malformed
+// or it can never be reached, all of them are reported when the server starts
+type RouteConflict struct {
+	Method  RequestMethod
+	Pattern string
+	// pattern of the mapping it collides with, if any
+	Existing string
+	Reason   string
+}
+
+func (c RouteConflict) Error() string {
+	if c.Existing != "" {
+		return fmt.Sprintf("%s %s: %s with %s", c.Method, c.Pattern, c.Reason, c.Existing)
+	}
+	return fmt.Sprintf("%s %s: %s", c.Method, c.Pattern, c.Reason)
+}
+
+var urlFormatRegexp []*regexp.Regexp
+
+func init() {
+	r1, _ := regexp.Compile("\\.+/")
+	r2, _ := regexp.Compile("/{2,}")
+	urlFormatRegexp = []*regexp.Regexp{r1, r2}
+}
+
+func newPrefixNode(prefix string) *prefixNode {
+	return &prefixNode{prefix: prefix, routes: map[RequestMethod]*route{}, children: map[string]*prefixNode{}}
+}
+
+func (s *RestServer) mapping(method RequestMethod, pattern string, handler interface{}, group *RouteGroup,
+	options []RouteOption) {
+
+	if s.controller.root == nil {
+		s.controller.root = newPrefixNode("")
+	}
+	method = RequestMethod(strings.ToUpper(strings.TrimSpace(string(method))))
+	if method == "" {
+		panic("empty method")
+	}
+	// format pattern
+	pattern = strings.TrimSpace(pattern)
+	if len(pattern) == 0 {
+		panic("empty pattern")
+	}
+	if pattern[0] == '/' {
+		if len(pattern) == 1 {
+			pattern = ""
+		} else {
+			pattern = pattern[1:]
+		}
+	}
+
+	conflict := func(reason string, existing string) {
+		c := RouteConflict{Method: method, Pattern: "/" + pattern, Existing: existing, Reason: reason}
+		s.controller.conflicts = append(s.controller.conflicts, c)
+		logger.Error("Mapping rejected", c.Error())
+	}
+
+	prefixes := strings.Split(pattern, "/")
+	params := []string{}
+	currentNode := s.controller.root
+	for i := 0; pattern != "" && i < len(prefixes); i++ {
+		prefix := prefixes[i]
+
+		name, constraint, ok, e := parsePlaceholder(prefix)
+		if e != nil {
+			conflict(e.Error(), "")
+			return
+		}
+		if !ok && len(prefix) > 0 && prefix[0] == '*' {
+			name = prefix[1:]
+		}
+		if ok || len(prefix) > 0 && prefix[0] == '*' {
+			for _, p := range params {
+				if p == name {
+					conflict("duplicate placeholder `"+name+"`", "")
+					return
+				}
+			}
+			params = append(params, name)
+		}
+
+		switch {
+		case ok:
+			currentNode = currentNode.paramChild(constraint)
+		case len(prefix) > 0 && prefix[0] == '*':
+			if i < len(prefixes)-1 {
+				conflict("catch-all placeholder must be the last segment", "")
+				return
+			}
+			if currentNode.catchAll == nil {
+				currentNode.catchAll = newPrefixNode("*")
+				currentNode.catchAll.parent = currentNode
+			}
+			currentNode = currentNode.catchAll
+		default:
+			nextNode := currentNode.children[prefix]
+
+			//allocate children node
+			if nextNode == nil {
+				nextNode = newPrefixNode(prefix)
+				nextNode.parent = currentNode
+				currentNode.children[prefix] = nextNode
+			}
+			currentNode = nextNode
+		}
+	}
+
+	requestHandler, e := adaptHandler(handler, s.validator)
+	if e != nil {
+		conflict(e.Error(), "")
+		return
+	}
+
+	// same method on the same node, the first one wins
+	if existing := currentNode.routes[method]; existing != nil {
+		conflict("ambiguous mapping", existing.pattern)
+		return
+	}
+
+	r := &route{method: method, pattern: "/" + pattern, handler: requestHandler, group: group, params: params,
+		handlerName: funcName(handler)}
+	for _, option := range options {
+		option(r)
+	}
+
+	if r.name != "" {
+		if existing := s.controller.names[r.name]; existing != nil {
+			conflict("duplicate route name `"+r.name+"`", existing.pattern)
+			return
+		}
+		if s.controller.names == nil {
+			s.controller.names = map[string]*route{}
+		}
+		s.controller.names[r.name] = r
+	}
+
+	currentNode.routes[method] = r
+	s.controller.routes = append(s.controller.routes, r)
+	logger.Info("URL Mapped", method, "/"+pattern)
+}
+
+// find or allocate the placeholder child with the same constraint
+func (n *prefixNode) paramChild(constraint *pathConstraint) *prefixNode {
+	for _, p := range n.params {
+		if p.constraint.String() == constraint.String() {
+			return p
+		}
+	}
+	child := newPrefixNode(constraint.String())
+	child.constraint = constraint
+	child.parent = n
+
+	// keep the unconstrained placeholder at the end
+	i := len(n.params)
+	if constraint != nil && i > 0 && n.params[i-1].constraint == nil {
+		i--
+	}
+	n.params = append(n.params, nil)
+	copy(n.params[i+1:], n.params[i:])
+	n.params[i] = child
+	return child
+}
+
+// find the node mapped to the url, values of the placeholders are returned in order.
+// A node mapped with the method is preferred, otherwise the first node mapped with
+// any method is returned to tell the allowed methods
+func (c *RestController) match(method RequestMethod, url string) (*prefixNode, []string) {
+	for _, reg := range urlFormatRegexp {
+		url = reg.ReplaceAllString(url, "/")
+	}
+
+	if c.root == nil || url == "" || url[0] != '/' {
+		return nil, nil
+	}
+
+	segments := segmentsOf(url)
+	if node, values := c.root.lookup(segments, nil, method); node != nil {
+		return node, values
+	}
+	return c.root.lookup(segments, nil, "")
+}
+
+// every node mapping the url under any method, as lookup would backtrack through them,
+// so that a static node and a placeholder node matching the same url are both reported
+func (c *RestController) candidates(url string) []*prefixNode {
+	for _, reg := range urlFormatRegexp {
+		url = reg.ReplaceAllString(url, "/")
+	}
+	if c.root == nil || url == "" || url[0] != '/' {
+		return nil
+	}
+	return c.root.collect(segmentsOf(url), nil)
+}
+
+func segmentsOf(url string) []string {
+	if url[1:] == "" {
+		return nil
+	}
+	return strings.Split(url[1:], "/")
+}
+
+// depth first search by precedence: static > placeholder > catch-all,
+// an empty method matches any mapped node
+func (n *prefixNode) lookup(segments []string, values []string, method RequestMethod) (*prefixNode, []string) {
+	if len(segments) == 0 {
+		if !n.mapped(method) {
+			return nil, nil
+		}
+		return n, values
+	}
+
+	segment := segments[0]
+	if child := n.children[segment]; child != nil {
+		if node, v := child.lookup(segments[1:], values, method); node != nil {
+			return node, v
+		}
+	}
+
+	for _, child := range n.params {
+		if child.constraint != nil && !child.constraint.match(segment) {
+			continue
+		}
+		if node, v := child.lookup(segments[1:], append(values[:len(values):len(values)], segment), method); node != nil {
+			return node, v
+		}
+	}
+
+	if n.catchAll != nil && n.catchAll.mapped(method) {
+		return n.catchAll, append(values, strings.Join(segments, "/"))
+	}
+	return nil, nil
+}
+
+// the nodes mapped under any method in the order of lookup
+func (n *prefixNode) collect(segments []string, nodes []*prefixNode) []*prefixNode {
+	if len(segments) == 0 {
+		if n.mapped("") {
+			nodes = append(nodes, n)
+		}
+		return nodes
+	}
+
+	if child := n.children[segments[0]]; child != nil {
+		nodes = child.collect(segments[1:], nodes)
+	}
+	for _, child := range n.params {
+		if child.constraint == nil || child.constraint.match(segments[0]) {
+			nodes = child.collect(segments[1:], nodes)
+		}
+	}
+	if n.catchAll != nil && n.catchAll.mapped("") {
+		nodes = append(nodes, n.catchAll)
+	}
+	return nodes
+}
+
+func (n *prefixNode) mapped(method RequestMethod) bool {
+	if method == "" {
+		return len(n.routes) > 0
+	}
+	return n.routes[method] != nil || method == Head && n.routes[Get] != nil
+}
+
+// name the matched values by the placeholders of the route
+func (r *route) pathVariables(values []string) map[string]string {
+	pv := make(map[string]string, len(values))
+	for i, name := range r.params {
+		if i < len(values) {
+			pv[name] = values[i]
+		}
+	}
+	return pv
+}
+
+// find the route of the request method, HEAD falls back to GET
+func (n *prefixNode) route(method RequestMethod) *route {
+	if r := n.routes[method]; r != nil {
+		return r
+	}
+	if method == Head {
+		return n.routes[Get]
+	}
+	return nil
+}
+
+// the answer of OPTIONS if it is not mapped explicitly, it runs inside the arounds of the route it answers for,
+// so that a CORS of the group or of the route answers the preflight. The interceptors of the group are not called
+func optionsRoute(allow string, of *route) *route {
+	rt := &route{method: Options, pattern: "", handler: func(ctx *common.RequestCtx) interface{} {
+		ctx.ResponseWriter.Header().Set("Allow", allow)
+		ctx.ResponseWriter.WriteHeader(http.StatusNoContent)
+		return nil
+	}}
+	if of != nil {
+		if of.group != nil {
+			rt.arounds = of.group.allArounds()
+		}
+		rt.arounds = append(rt.arounds, of.arounds...)
+	}
+	return rt
+}
+
+// the route a preflight asks for by Access-Control-Request-Method, HEAD falls back to GET,
+// the first method of the first node otherwise
+func preflightRoute(nodes []*prefixNode, method RequestMethod) *route {
+	if method != "" {
+		for _, n := range nodes {
+			if r := n.route(method); r != nil {
+				return r
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	methods := make([]string, 0, len(nodes[0].routes))
+	for m := range nodes[0].routes {
+		methods = append(methods, string(m))
+	}
+	sort.Strings(methods)
+	return nodes[0].routes[RequestMethod(methods[0])]
+}
+
+// value of the Allow header, the methods of every node matching the url
+func allowOf(nodes []*prefixNode) string {
+	set := map[string]bool{string(Options): true}
+	for _, n := range nodes {
+		for m := range n.routes {
+			set[string(m)] = true
+		}
+		if n.routes[Get] != nil {
+			set[string(Head)] = true
+		}
+	}
+	methods := make([]string, 0, len(set))
+	for m := range set {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
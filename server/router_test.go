@@ -0,0 +1,196 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"fmt"
+	"github.com/azzill/goze/common"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestMethods(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	reply := func(v string) RequestHandler {
+		return func(ctx *common.RequestCtx) interface{} {
+			return v + " " + ctx.PathVariable["id"]
+		}
+	}
+	s.GET("/users/:id", reply("get"))
+	s.PATCH("/users/:uid", func(ctx *common.RequestCtx) interface{} {
+		return "patch " + ctx.PathVariable["uid"]
+	})
+	s.Mapping("purge", "/users/:id", reply("purge"))
+	s.POST("/users", reply("post"))
+	s.PUT("/users/me", reply("put"))
+
+	cases := []struct {
+		method string
+		url    string
+		status int
+		body   string
+		allow  string
+	}{
+		{http.MethodGet, "/users/1", http.StatusOK, "get 1", ""},
+		{http.MethodGet, "/users/me", http.StatusOK, "get me", ""},
+		{http.MethodDelete, "/users/me", http.StatusMethodNotAllowed, "", "GET, HEAD, OPTIONS, PATCH, PURGE, PUT"},
+		{http.MethodOptions, "/users/me", http.StatusNoContent, "", "GET, HEAD, OPTIONS, PATCH, PURGE, PUT"},
+		{http.MethodPatch, "/users/2", http.StatusOK, "patch 2", ""},
+		{"PURGE", "/users/3", http.StatusOK, "purge 3", ""},
+		{http.MethodHead, "/users/4", http.StatusOK, "get 4", ""},
+		{http.MethodOptions, "/users/5", http.StatusNoContent, "", "GET, HEAD, OPTIONS, PATCH, PURGE"},
+		{http.MethodDelete, "/users/6", http.StatusMethodNotAllowed, "", "GET, HEAD, OPTIONS, PATCH, PURGE"},
+		{http.MethodGet, "/users", http.StatusMethodNotAllowed, "", "OPTIONS, POST"},
+		{http.MethodGet, "/groups", http.StatusNotFound, "", ""},
+	}
+
+	for _, c := range cases {
+		wr := serve(s, c.method, c.url, nil)
+		if wr.Code != c.status {
+			t.Error(c.method, c.url, "expected status", c.status, "but got", wr.Code)
+		}
+		if c.body != "" && wr.Body.String() != c.body {
+			t.Error(c.method, c.url, "expected", c.body, "but got", wr.Body.String())
+		}
+		if allow := wr.Header().Get("Allow"); allow != c.allow {
+			t.Error(c.method, c.url, "expected Allow", c.allow, "but got", allow)
+		}
+	}
+}
+
+func TestTypedPlaceholder(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	s.GET("/users/{name}", func(ctx *common.RequestCtx) interface{} {
+		return "name " + ctx.PathVariable["name"]
+	})
+	s.GET("/users/{id:int}", func(ctx *common.RequestCtx) interface{} {
+		return fmt.Sprint("id ", ctx.PathInt("id")+1)
+	})
+	s.GET("/users/{uuid:uuid}", func(ctx *common.RequestCtx) interface{} {
+		return "uuid " + ctx.PathVariable["uuid"]
+	})
+	s.GET(`/files/{name:[a-z]+\.txt}`, func(ctx *common.RequestCtx) interface{} {
+		return "text " + ctx.PathVariable["name"]
+	})
+	s.GET("/files/*path", func(ctx *common.RequestCtx) interface{} {
+		return "file " + ctx.PathVariable["path"]
+	})
+
+	cases := map[string]string{
+		"/users/41":   "id 42",
+		"/users/goze": "name goze",
+		"/users/123e4567-e89b-12d3-a456-426614174000": "uuid 123e4567-e89b-12d3-a456-426614174000",
+		"/files/readme.txt":                           "text readme.txt",
+		"/files/README.txt":                           "file README.txt",
+		"/files/docs/readme.txt":                      "file docs/readme.txt",
+	}
+	for url, body := range cases {
+		if wr := serve(s, http.MethodGet, url, nil); wr.Body.String() != body {
+			t.Error(url, "expected", body, "but got", wr.Body.String())
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	reply := func(v string) RequestHandler {
+		return func(ctx *common.RequestCtx) interface{} {
+			return fmt.Sprint(v, " ", ctx.PathVariable)
+		}
+	}
+	s.GET("/files/static/x", reply("static"))
+	s.GET("/files/:dir/y", reply("param"))
+	s.GET("/files/*path", reply("catch-all"))
+	s.GET("/files/:dir", reply("dir"))
+
+	cases := map[string]string{
+		"/files/static/x": "static map[]",
+		"/files/static/y": "param map[dir:static]",
+		"/files/other/y":  "param map[dir:other]",
+		"/files/static":   "dir map[dir:static]",
+		"/files/static/z": "catch-all map[path:static/z]",
+		"/files/a/b/c":    "catch-all map[path:a/b/c]",
+	}
+	for url, body := range cases {
+		if wr := serve(s, http.MethodGet, url, nil); wr.Body.String() != body {
+			t.Error(url, "expected", body, "but got", wr.Body.String())
+		}
+	}
+
+	if len(s.Conflicts()) != 0 {
+		t.Error("unexpected conflicts", s.Conflicts())
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	handler := func(ctx *common.RequestCtx) interface{} {
+		return nil
+	}
+	s.GET("/users/:id", handler)
+	s.GET("/users/:name", handler)
+	s.GET("/users/*rest/edit", handler)
+	s.GET("/users/:id/friends/:id", handler)
+	s.GET("/users/{id:[0-9}", handler)
+	s.PUT("/users/:name", handler)
+
+	conflicts := s.Conflicts()
+	if len(conflicts) != 4 {
+		t.Fatal("expected 4 conflicts but got", conflicts)
+	}
+	if conflicts[0].Existing != "/users/:id" {
+		t.Error("expected conflict with /users/:id but got", conflicts[0])
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("server started with conflicts")
+		}
+	}()
+	s.StartServerAsync()
+}
+
+func TestURLFor(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	handler := func(ctx *common.RequestCtx) interface{} {
+		return nil
+	}
+	api := s.Group("/api/v1")
+	api.GET("/users/{id:int}", handler, Named("user.show"))
+	api.GET("/users/:id/files/*path", handler, Named("user.file"))
+	s.GET("/", handler, Named("home"))
+	s.GET("/index", handler, Named("home"))
+
+	cases := []struct {
+		name   string
+		params map[string]string
+		query  url.Values
+		url    string
+	}{
+		{"user.show", map[string]string{"id": "42"}, nil, "/api/v1/users/42"},
+		{"user.show", map[string]string{"id": "42"}, url.Values{"tab": {"posts"}}, "/api/v1/users/42?tab=posts"},
+		{"user.file", map[string]string{"id": "a b", "path": "docs/read me.md"}, nil,
+			"/api/v1/users/a%20b/files/docs/read%20me.md"},
+		{"home", nil, nil, "/"},
+	}
+	for _, c := range cases {
+		if u, e := s.URLFor(c.name, c.params, c.query); e != nil || u != c.url {
+			t.Error(c.name, "expected", c.url, "but got", u, e)
+		}
+	}
+
+	if _, e := s.URLFor("user.show", map[string]string{"id": "me"}, nil); e == nil {
+		t.Error("constraint of placeholder is not checked")
+	}
+	if _, e := s.URLFor("user.file", map[string]string{"id": "42"}, nil); e == nil {
+		t.Error("missing placeholder is not reported")
+	}
+	if len(s.Conflicts()) != 1 {
+		t.Error("duplicate route name is not reported")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"fmt"
+	"github.com/azzill/goze/common"
+	"reflect"
+	"runtime"
+)
+
+// default pattern of the built-in route table endpoint
+const RoutesEndpoint = "/_goze/routes"
+
+// RouteInfo describes a registered route
+type RouteInfo struct {
+	Method  RequestMethod `json:"method"`
+	Pattern string        `json:"pattern"`
+	Name    string        `json:"name,omitempty"`
+	Handler string        `json:"handler"`
+	// global interceptors first, then the ones of the route group
+	Interceptors []string `json:"interceptors"`
+	// outermost first, the ones of the server, then of the route groups, then of the route
+	Arounds  []string               `json:"arounds"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// all the routes in the order they are registered
+func (s *RestServer) Routes() []RouteInfo {
+	global := s.controller.requestInterceptor.Interceptors()
+	globalArounds := s.controller.arounds.Arounds()
+	infos := make([]RouteInfo, 0, len(s.controller.routes))
+	for _, r := range s.controller.routes {
+		info := RouteInfo{Method: r.method, Pattern: r.pattern, Name: r.name, Handler: r.handlerName,
+			Interceptors: []string{}, Arounds: []string{}, Metadata: r.metadata}
+		for _, i := range global {
+			info.Interceptors = append(info.Interceptors, fmt.Sprintf("%T", i))
+		}
+		for _, a := range globalArounds {
+			info.Arounds = append(info.Arounds, fmt.Sprintf("%T", a))
+		}
+		var groups []*RouteGroup
+		for g := r.group; g != nil; g = g.parent {
+			groups = append([]*RouteGroup{g}, groups...)
+		}
+		for _, g := range groups {
+			for _, i := range g.interceptor.Interceptors() {
+				info.Interceptors = append(info.Interceptors, fmt.Sprintf("%T", i))
+			}
+			for _, a := range g.arounds.Arounds() {
+				info.Arounds = append(info.Arounds, fmt.Sprintf("%T", a))
+			}
+		}
+		for _, a := range r.arounds {
+			info.Arounds = append(info.Arounds, fmt.Sprintf("%T", a))
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// serve the route table as json, global interceptors apply to it as well
+func (s *RestServer) ExposeRoutes(pattern string, options ...RouteOption) *RestServer {
+	return s.GET(pattern, func(ctx *common.RequestCtx) interface{} {
+		return s.Routes()
+	}, options...)
+}
+
+// serve the route table under the group, so that it is protected by the group interceptors
+func (g *RouteGroup) ExposeRoutes(pattern string, options ...RouteOption) *RouteGroup {
+	return g.GET(pattern, func(ctx *common.RequestCtx) interface{} {
+		return g.server.Routes()
+	}, options...)
+}
+
+func funcName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+	if fn := runtime.FuncForPC(v.Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
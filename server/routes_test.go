@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"github.com/azzill/goze/midware"
+	"net/http"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{})
+	s.AddInterceptor(&headerInterceptor{header: "X-Token"})
+	s.AddAround(&midware.CORS{})
+	admin := s.Group("/admin", &headerInterceptor{header: "X-Admin"})
+	admin.AddAround(&midware.Compression{})
+	admin.DELETE("/users/:id", deleteUser, Named("user.delete"), Arounds(midware.AroundFunc(nil)))
+	admin.ExposeRoutes(RoutesEndpoint)
+
+	routes := s.Routes()
+	if len(routes) != 2 {
+		t.Fatal("expected 2 routes but got", routes)
+	}
+	r := routes[0]
+	if r.Method != Delete || r.Pattern != "/admin/users/:id" || r.Name != "user.delete" ||
+		r.Handler != "github.com/azzill/goze/server.deleteUser" {
+		t.Error("unexpected route", r)
+	}
+	if len(r.Interceptors) != 2 || r.Interceptors[0] != "*server.headerInterceptor" {
+		t.Error("unexpected interceptors", r.Interceptors)
+	}
+	if fmt.Sprint(r.Arounds) != "[*midware.CORS *midware.Compression midware.AroundFunc]" {
+		t.Error("unexpected arounds", r.Arounds)
+	}
+	if fmt.Sprint(routes[1].Arounds) != "[*midware.CORS *midware.Compression]" {
+		t.Error("unexpected arounds", routes[1].Arounds)
+	}
+
+	wr := serve(s, http.MethodGet, "/admin/_goze/routes", map[string]string{"X-Token": "1", "X-Admin": "1"})
+	var infos []RouteInfo
+	if e := json.Unmarshal(wr.Body.Bytes(), &infos); e != nil || len(infos) != 2 || infos[1].Pattern != "/admin/_goze/routes" {
+		t.Error("unexpected route table", wr.Body.String())
+	}
+}
+
+func deleteUser(ctx *common.RequestCtx) interface{} {
+	return nil
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type StaticOption func(h *staticHandler)
+
+// index files served for directories, index.html by default
+func StaticIndex(names ...string) StaticOption {
+	return func(h *staticHandler) {
+		h.index = names
+	}
+}
+
+// list the directories without index files, they are 404 by default
+func StaticBrowse(browse bool) StaticOption {
+	return func(h *staticHandler) {
+		h.browse = browse
+	}
+}
+
+// Cache-Control max-age of the files, not sent if zero
+func StaticMaxAge(maxAge time.Duration) StaticOption {
+	return func(h *staticHandler) {
+		h.maxAge = maxAge
+	}
+}
+
+// serve the files and directories whose name starts with a dot, eg: .env, they are 404 by default
+func StaticHidden(hidden bool) StaticOption {
+	return func(h *staticHandler) {
+		h.hidden = hidden
+	}
+}
+
+// precompressed siblings of a file, preferred in this order if the client accepts them
+var precompressed = []struct {
+	encoding string
+	ext      string
+}{{"br", ".br"}, {"gzip", ".gz"}}
+
+type staticHandler struct {
+	fsys   fs.FS
+	index  []string
+	browse bool
+	maxAge time.Duration
+	hidden bool
+	// etags of the files without modification time, eg: embedded files
+	etags sync.Map
+}
+
+// serve the files under dir at prefix, eg: s.Static("/assets", "./public")
+func (s *RestServer) Static(prefix string, dir string, options ...StaticOption) *RestServer {
+	return s.StaticFS(prefix, os.DirFS(dir), options...)
+}
+
+// serve the files of fsys at prefix, eg: an embed.FS
+func (s *RestServer) StaticFS(prefix string, fsys fs.FS, options ...StaticOption) *RestServer {
+	s.static(prefix, fsys, nil, options)
+	return s
+}
+
+func (g *RouteGroup) Static(prefix string, dir string, options ...StaticOption) *RouteGroup {
+	return g.StaticFS(prefix, os.DirFS(dir), options...)
+}
+
+func (g *RouteGroup) StaticFS(prefix string, fsys fs.FS, options ...StaticOption) *RouteGroup {
+	g.server.static(joinPattern(g.prefix, prefix), fsys, g, options)
+	return g
+}
+
+func (s *RestServer) static(prefix string, fsys fs.FS, group *RouteGroup, options []StaticOption) {
+	h := &staticHandler{fsys: fsys, index: []string{"index.html"}}
+	for _, option := range options {
+		option(h)
+	}
+	prefix = strings.TrimRight(prefix, "/")
+	s.mapping(Get, prefix+"/*filepath", RequestHandler(h.serve), group, nil)
+	// the prefix itself is redirected to the directory, or is the root directory
+	if prefix == "" {
+		s.mapping(Get, "/", RequestHandler(h.serve), group, nil)
+	} else {
+		s.mapping(Get, prefix, RequestHandler(h.serve), group, nil)
+	}
+}
+
+func (h *staticHandler) serve(ctx *common.RequestCtx) interface{} {
+	r := ctx.Request
+	value, has := ctx.PathVariable["filepath"]
+	if !has && !strings.HasSuffix(r.URL.Path, "/") {
+		return redirectToDir(r)
+	}
+
+	name := path.Clean("/" + value)[1:]
+	if name == "" {
+		name = "."
+	}
+	// traversal is rejected by fs.ValidPath
+	if !fs.ValidPath(name) || !h.hidden && hasHiddenElem(name) {
+		return NotFound(r.URL.Path + " is not found")
+	}
+
+	stat, e := fs.Stat(h.fsys, name)
+	if e != nil {
+		return NotFound(r.URL.Path + " is not found")
+	}
+	if stat.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			return redirectToDir(r)
+		}
+		for _, index := range h.index {
+			indexName := path.Join(name, index)
+			if stat, e := fs.Stat(h.fsys, indexName); e == nil && !stat.IsDir() {
+				return h.serveFile(ctx.ResponseWriter, r, indexName)
+			}
+		}
+		if h.browse {
+			return h.list(ctx.ResponseWriter, r, name)
+		}
+		return NotFound(r.URL.Path + " is not found")
+	}
+	return h.serveFile(ctx.ResponseWriter, r, name)
+}
+
+// the location is cleaned and escaped, so that a path like //evil.com/dir or /\evil.com
+// is not taken by browsers for another host
+func redirectToDir(r *http.Request) *Response {
+	dir := path.Clean("/" + r.URL.Path)
+	if dir != "/" {
+		dir += "/"
+	}
+	location := (&url.URL{Path: dir}).EscapedPath()
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	return Redirect(http.StatusMovedPermanently, location)
+}
+
+func hasHiddenElem(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *staticHandler) serveFile(wr http.ResponseWriter, r *http.Request, name string) interface{} {
+	served := name
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	for _, p := range precompressed {
+		if stat, e := fs.Stat(h.fsys, name+p.ext); e != nil || stat.IsDir() {
+			continue
+		}
+		wr.Header().Add("Vary", "Accept-Encoding")
+		if served == name && acceptsEncoding(acceptEncoding, p.encoding) {
+			served = name + p.ext
+			wr.Header().Set("Content-Encoding", p.encoding)
+		}
+	}
+
+	f, e := h.fsys.Open(served)
+	if e != nil {
+		wr.Header().Del("Content-Encoding")
+		return NotFound(r.URL.Path + " is not found")
+	}
+	defer f.Close()
+	stat, e := f.Stat()
+	if e != nil {
+		wr.Header().Del("Content-Encoding")
+		return e
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, e := io.ReadAll(f)
+		if e != nil {
+			return e
+		}
+		content = bytes.NewReader(b)
+	}
+
+	etag, e := h.etag(served, stat, content)
+	if e != nil {
+		return e
+	}
+	wr.Header().Set("ETag", etag)
+	if h.maxAge > 0 {
+		wr.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.maxAge/time.Second)))
+	}
+	// the content type is detected by the name of the uncompressed file,
+	// If-None-Match, If-Modified-Since and Range are handled by ServeContent
+	http.ServeContent(wr, r, path.Base(name), stat.ModTime(), content)
+	return nil
+}
+
+// whether the encoding is listed in Accept-Encoding without q=0
+func acceptsEncoding(accept string, encoding string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), encoding) {
+			continue
+		}
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if q, e := strconv.ParseFloat(kv[1], 64); e == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// weak etag by the size and modification time, or strong etag by the content
+// if the modification time is unknown
+func (h *staticHandler) etag(name string, stat fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !stat.ModTime().IsZero() {
+		return fmt.Sprintf(`W/"%x-%x"`, stat.Size(), stat.ModTime().UnixNano()), nil
+	}
+	if etag, ok := h.etags.Load(name); ok {
+		return etag.(string), nil
+	}
+	hash := sha256.New()
+	if _, e := io.Copy(hash, content); e != nil {
+		return "", e
+	}
+	if _, e := content.Seek(0, io.SeekStart); e != nil {
+		return "", e
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	h.etags.Store(name, etag)
+	return etag, nil
+}
+
+var listTemplate = template.Must(template.New("list").Parse(
+	"<!DOCTYPE html>\n<html><head><title>{{.Path}}</title></head><body><h1>{{.Path}}</h1><ul>\n" +
+		"{{range .Entries}}<li><a href=\"{{.Href}}\">{{.Name}}</a></li>\n{{end}}</ul></body></html>\n"))
+
+func (h *staticHandler) list(wr http.ResponseWriter, r *http.Request, name string) interface{} {
+	entries, e := fs.ReadDir(h.fsys, name)
+	if e != nil {
+		return e
+	}
+
+	type entry struct {
+		Name string
+		Href string
+	}
+	data := struct {
+		Path    string
+		Entries []entry
+	}{Path: r.URL.Path}
+	for _, en := range entries {
+		if !h.hidden && strings.HasPrefix(en.Name(), ".") {
+			continue
+		}
+		n := en.Name()
+		if en.IsDir() {
+			n += "/"
+		}
+		data.Entries = append(data.Entries, entry{Name: n, Href: (&url.URL{Path: n}).String()})
+	}
+
+	buf := &bytes.Buffer{}
+	if e := listTemplate.Execute(buf, data); e != nil {
+		return e
+	}
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = wr.Write(buf.Bytes())
+	return nil
+}
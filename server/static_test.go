@@ -0,0 +1,108 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStatic(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "docs"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>home</h1>"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(1)"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "app.js.gz"), []byte("gzipped"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "docs", "a<b>.txt"), []byte("a"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET=1"), 0644)
+	_ = os.WriteFile(filepath.Join(filepath.Dir(dir), "outside.txt"), []byte("outside"), 0644)
+	defer os.Remove(filepath.Join(filepath.Dir(dir), "outside.txt"))
+
+	s := NewRestServer(":8080", nil)
+	s.Static("/assets", dir, StaticBrowse(true), StaticMaxAge(time.Hour))
+
+	wr := serve(s, "GET", "/assets/app.js", nil)
+	if wr.Code != http.StatusOK || wr.Body.String() != "console.log(1)" || wr.Header().Get("Vary") != "Accept-Encoding" ||
+		!strings.HasPrefix(wr.Header().Get("Content-Type"), "text/javascript") ||
+		wr.Header().Get("Cache-Control") != "public, max-age=3600" || wr.Header().Get("Last-Modified") == "" {
+		t.Error("unexpected file response", wr.Code, wr.Header(), wr.Body.String())
+	}
+	etag := wr.Header().Get("ETag")
+	if wr := serve(s, "GET", "/assets/app.js", map[string]string{"If-None-Match": etag}); wr.Code != http.StatusNotModified {
+		t.Error("etag is not matched", etag, wr.Code)
+	}
+
+	// precompressed sibling
+	wr = serve(s, "GET", "/assets/app.js", map[string]string{"Accept-Encoding": "br;q=0, gzip"})
+	if wr.Body.String() != "gzipped" || wr.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.HasPrefix(wr.Header().Get("Content-Type"), "text/javascript") {
+		t.Error("precompressed file is not served", wr.Header(), wr.Body.String())
+	}
+
+	// index, redirect of directories and listing
+	if wr := serve(s, "GET", "/assets/", nil); wr.Body.String() != "<h1>home</h1>" {
+		t.Error("index is not served", wr.Code, wr.Body.String())
+	}
+	if wr := serve(s, "GET", "/assets", nil); wr.Code != http.StatusMovedPermanently || wr.Header().Get("Location") != "/assets/" {
+		t.Error("prefix is not redirected", wr.Code, wr.Header())
+	}
+	if wr := serve(s, "GET", "/assets/docs?x=1", nil); wr.Code != http.StatusMovedPermanently ||
+		wr.Header().Get("Location") != "/assets/docs/?x=1" {
+		t.Error("directory is not redirected", wr.Code, wr.Header())
+	}
+	for path, location := range map[string]string{"//evil.com/dir": "/evil.com/dir/", "/\\evil.com": "/%5Cevil.com/",
+		"/a/..": "/"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = path
+		if l := redirectToDir(r).Header.Get("Location"); l != location {
+			t.Error(path, "is redirected to", l)
+		}
+	}
+	if wr := serve(s, "GET", "/assets/docs/", nil); !strings.Contains(wr.Body.String(), "a&lt;b&gt;.txt") {
+		t.Error("unexpected listing", wr.Body.String())
+	}
+
+	// traversal and hidden files
+	for _, url := range []string{"/assets/../outside.txt", "/assets/%2e%2e/outside.txt", "/assets/docs/..", "/assets/.env"} {
+		if wr := serve(s, "GET", url, nil); wr.Code != http.StatusNotFound && wr.Code != http.StatusMovedPermanently {
+			t.Error(url, "responded with", wr.Code, wr.Body.String())
+		} else if wr.Body.String() == "outside" || strings.Contains(wr.Body.String(), "SECRET") {
+			t.Error(url, "is served")
+		}
+	}
+}
+
+func TestStaticFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"web/index.htm": {Data: []byte("embedded")},
+		"web/docs/a":    {Data: []byte("a")},
+	}
+	s := NewRestServer(":8080", nil)
+	s.StaticFS("/", fsys, StaticIndex("index.htm"))
+
+	wr := serve(s, "GET", "/web/", nil)
+	etag := wr.Header().Get("ETag")
+	if wr.Code != http.StatusOK || wr.Body.String() != "embedded" || !strings.HasPrefix(etag, `"`) {
+		t.Error("unexpected embedded response", wr.Code, wr.Header(), wr.Body.String())
+	}
+	if wr := serve(s, "GET", "/web/index.htm", map[string]string{"If-None-Match": etag}); wr.Code != http.StatusNotModified {
+		t.Error("etag is not matched", etag, wr.Code)
+	}
+	// listing is disabled by default
+	if wr := serve(s, "GET", "/web/docs/", nil); wr.Code != http.StatusNotFound {
+		t.Error("directory is listed", wr.Code)
+	}
+	if wr := serve(s, "GET", "/", nil); wr.Code != http.StatusNotFound {
+		t.Error("root without index responded with", wr.Code)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream returned by handlers writes the body incrementally, every write is flushed to the client,
+// the Content-Type should be set by the headers of a Response, application/octet-stream by default
+type Stream func(w io.Writer) error
+
+// SSE returned by handlers serves Server-Sent Events until it returns, the client disconnects
+// or the server shuts down
+type SSE func(events *EventStream) error
+
+// Attachment responds the file as a download named name, Range requests are supported
+func Attachment(f *os.File, name string) *Response {
+	return NewResponse(http.StatusOK, f).
+		SetHeader("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+}
+
+// files are served with http.ServeContent, so Range, If-Modified-Since and HEAD are handled
+func serveFile(wr http.ResponseWriter, r *http.Request, f *os.File) error {
+	defer f.Close()
+	stat, e := f.Stat()
+	if e != nil {
+		return e
+	}
+	if stat.IsDir() {
+		return NotFound(filepath.Base(f.Name()) + " is a directory")
+	}
+	http.ServeContent(wr, r, stat.Name(), stat.ModTime(), f)
+	return nil
+}
+
+// readers are copied without buffering the whole body, Content-Length is set when the
+// length is known (eg: bytes.Reader, strings.Reader), otherwise it is chunked
+func writeReader(wr http.ResponseWriter, reader io.Reader) error {
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	header := wr.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/octet-stream")
+	}
+	if l, ok := reader.(interface{ Len() int }); ok && header.Get("Content-Length") == "" {
+		header.Set("Content-Length", strconv.Itoa(l.Len()))
+	}
+	_, e := io.Copy(wr, reader)
+	return e
+}
+
+func (s Stream) write(wr http.ResponseWriter) error {
+	clearWriteDeadline(wr)
+	if wr.Header().Get("Content-Type") == "" {
+		wr.Header().Set("Content-Type", "application/octet-stream")
+	}
+	return s(&flushWriter{wr: wr})
+}
+
+// streams last longer than the WriteTimeout of the server
+func clearWriteDeadline(wr http.ResponseWriter) {
+	_ = http.NewResponseController(wr).SetWriteDeadline(time.Time{})
+}
+
+type flushWriter struct {
+	wr http.ResponseWriter
+}
+
+func (w *flushWriter) Write(b []byte) (int, error) {
+	n, e := w.wr.Write(b)
+	if f, ok := w.wr.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, e
+}
+
+// Event of Server-Sent Events, Data is written as is if it is a string, otherwise it is
+// encoded as json, multiple lines are sent as multiple data fields
+type Event struct {
+	Id    string
+	Event string
+	Data  interface{}
+	// reconnection time of the client, not sent if zero
+	Retry time.Duration
+}
+
+type EventStream struct {
+	wr      http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+	lastId  string
+}
+
+// the stream ends when the client disconnects or the server shuts down
+func (s SSE) serve(wr http.ResponseWriter, r *http.Request, stopping <-chan struct{}) error {
+	flusher, ok := wr.(http.Flusher)
+	if !ok {
+		return InternalServerError("streaming is not supported by the connection")
+	}
+	clearWriteDeadline(wr)
+	header := wr.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// disable buffering of nginx
+	header.Set("X-Accel-Buffering", "no")
+	wr.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	e := s(&EventStream{wr: wr, flusher: flusher, ctx: ctx, lastId: r.Header.Get("Last-Event-ID")})
+	// disconnected by the client
+	if e == context.Canceled {
+		return nil
+	}
+	return e
+}
+
+// closed when the client disconnects or the server shuts down
+func (s *EventStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Last-Event-ID sent by a reconnecting client
+func (s *EventStream) LastEventId() string {
+	return s.lastId
+}
+
+// send an event and flush it, the error of the context is returned if the client is disconnected
+func (s *EventStream) Send(event Event) error {
+	if e := s.ctx.Err(); e != nil {
+		return e
+	}
+
+	var data string
+	switch d := event.Data.(type) {
+	case nil:
+	case string:
+		data = d
+	case []byte:
+		data = string(d)
+	default:
+		b, e := json.Marshal(d)
+		if e != nil {
+			return e
+		}
+		data = string(b)
+	}
+
+	b := &strings.Builder{}
+	if event.Id != "" {
+		_, _ = fmt.Fprintf(b, "id: %s\n", oneLine(event.Id))
+	}
+	if event.Event != "" {
+		_, _ = fmt.Fprintf(b, "event: %s\n", oneLine(event.Event))
+	}
+	if event.Retry > 0 {
+		_, _ = fmt.Fprintf(b, "retry: %d\n", event.Retry/time.Millisecond)
+	}
+	// a line ends with \r\n, \r or \n for the clients
+	for _, line := range strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(data), "\n") {
+		_, _ = fmt.Fprintf(b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// send a comment, which is ignored by clients and keeps the connection alive
+func (s *EventStream) Comment(comment string) error {
+	if e := s.ctx.Err(); e != nil {
+		return e
+	}
+	return s.write(": " + oneLine(comment) + "\n\n")
+}
+
+func (s *EventStream) write(text string) error {
+	if _, e := io.WriteString(s.wr, text); e != nil {
+		return e
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// fields other than data can not contain line breaks
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
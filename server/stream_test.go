@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	f, e := ioutil.TempFile("", "goze-*.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString("0123456789")
+	_ = f.Close()
+
+	s := NewRestServer(":8080", nil)
+	s.GET("/reader", func(ctx *common.RequestCtx) interface{} {
+		return strings.NewReader("plain reader")
+	})
+	s.GET("/file", func(ctx *common.RequestCtx) interface{} {
+		f, e := os.Open(f.Name())
+		if e != nil {
+			return e
+		}
+		return Attachment(f, "digits.txt")
+	})
+	s.GET("/stream", func(ctx *common.RequestCtx) interface{} {
+		return NewResponse(http.StatusOK, Stream(func(w io.Writer) error {
+			for i := 0; i < 3; i++ {
+				if _, e := fmt.Fprintf(w, "chunk%d;", i); e != nil {
+					return e
+				}
+			}
+			return nil
+		})).SetHeader("Content-Type", "text/plain")
+	})
+
+	wr := serve(s, "GET", "/reader", nil)
+	if wr.Body.String() != "plain reader" || wr.Header().Get("Content-Length") != "12" ||
+		wr.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Error("unexpected reader response", wr.Header(), wr.Body.String())
+	}
+
+	wr = serve(s, "GET", "/file", map[string]string{"Range": "bytes=2-5"})
+	if wr.Code != http.StatusPartialContent || wr.Body.String() != "2345" ||
+		!strings.Contains(wr.Header().Get("Content-Disposition"), "digits.txt") {
+		t.Error("unexpected range response", wr.Code, wr.Header(), wr.Body.String())
+	}
+	if wr := serve(s, "GET", "/file", nil); wr.Code != http.StatusOK || wr.Body.String() != "0123456789" {
+		t.Error("unexpected file response", wr.Code, wr.Body.String())
+	}
+
+	wr = serve(s, "GET", "/stream", nil)
+	if wr.Body.String() != "chunk0;chunk1;chunk2;" || !wr.Flushed || wr.Header().Get("Content-Type") != "text/plain" {
+		t.Error("unexpected stream response", wr.Header(), wr.Body.String())
+	}
+}
+
+func TestSSE(t *testing.T) {
+	s := NewRestServer(":8080", nil)
+	stopped := make(chan struct{})
+	s.GET("/events", func(ctx *common.RequestCtx) interface{} {
+		return SSE(func(events *EventStream) error {
+			defer close(stopped)
+			if e := events.Send(Event{Id: "1\r", Event: "greeting\r\n", Data: "hello\nworld\r\nof\rgoze"}); e != nil {
+				return e
+			}
+			if e := events.Send(Event{Data: map[string]int{"n": 2}, Retry: time.Second}); e != nil {
+				return e
+			}
+			if events.LastEventId() == "" {
+				return nil
+			}
+			// keep streaming until the client disconnects
+			for {
+				select {
+				case <-events.Done():
+					return events.Comment("bye")
+				case <-time.After(time.Millisecond):
+					_ = events.Comment("ping")
+				}
+			}
+		})
+	})
+
+	wr := serve(s, "GET", "/events", nil)
+	expected := "id: 1\nevent: greeting\ndata: hello\ndata: world\ndata: of\ndata: goze\n\nretry: 1000\ndata: {\"n\":2}\n\n"
+	if wr.Header().Get("Content-Type") != "text/event-stream" || wr.Body.String() != expected {
+		t.Errorf("unexpected events %q", wr.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	r.Header.Set("Last-Event-ID", "1")
+	stopped = make(chan struct{})
+	go s.controller.ServeHTTP(httptest.NewRecorder(), r)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("event stream is not stopped after the client disconnected")
+	}
+}
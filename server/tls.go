@@ -0,0 +1,108 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// TLSConfig enables https if CertFile is set, HTTP/2 is negotiated automatically unless it is disabled
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// 1.0, 1.1, 1.2 or 1.3, 1.2 by default
+	MinVersion string
+	// CA certificates (PEM) verifying the certificates of clients, for mutual TLS
+	ClientCAFile string
+	// request, require, verify-if-given or require-and-verify,
+	// require-and-verify by default if ClientCAFile is set
+	ClientAuth string
+	// address of a plain http listener redirecting to https, eg: :80, disabled if empty
+	RedirectAddr string
+	DisableHTTP2 bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+func (c *TLSConfig) enabled() bool {
+	return c != nil && c.CertFile != ""
+}
+
+// build the tls.Config of the server, the files are loaded when the server starts
+func (c *TLSConfig) build() (*tls.Config, error) {
+	cert, e := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if e != nil {
+		return nil, fmt.Errorf("failed to load the certificate: %v", e)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if c.MinVersion != "" {
+		version, has := tlsVersions[c.MinVersion]
+		if !has {
+			return nil, fmt.Errorf("unknown tls version `%s`", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if c.ClientCAFile != "" {
+		pem, e := os.ReadFile(c.ClientCAFile)
+		if e != nil {
+			return nil, fmt.Errorf("failed to load the client CA: %v", e)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate is found in %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if c.ClientAuth != "" {
+		auth, has := clientAuthTypes[c.ClientAuth]
+		if !has {
+			return nil, fmt.Errorf("unknown client auth `%s`", c.ClientAuth)
+		}
+		config.ClientAuth = auth
+	}
+
+	if !c.DisableHTTP2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return config, nil
+}
+
+// redirect plain http requests to the https address
+func redirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, e := net.SplitHostPort(host); e == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(wr, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
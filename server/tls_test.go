@@ -0,0 +1,160 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/azzill/goze/common"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// issue a certificate signed by parent, self-signed if parent is nil
+func issueCert(t *testing.T, dir string, name string, parent *testCert, isCA bool) *testCert {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, e := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	c := &testCert{cert: cert, key: key, certFile: filepath.Join(dir, name+".crt"), keyFile: filepath.Join(dir, name+".key")}
+	_ = os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return c
+}
+
+func freeAddr(t *testing.T) string {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitListening(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		if c, e := net.Dial("tcp", addr); e == nil {
+			_ = c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(addr, "is not listening")
+}
+
+func TestHttpConfig(t *testing.T) {
+	s := NewRestServer(":8080", &HttpConfig{ReadTimeout: time.Second, ReadHeaderTimeout: 2 * time.Second,
+		WriteTimeout: 3 * time.Second, IdleTimeout: 4 * time.Second, MaxHeaderBytes: 1024})
+	server := s.httpServer()
+	if server.ReadTimeout != time.Second || server.ReadHeaderTimeout != 2*time.Second || server.WriteTimeout != 3*time.Second ||
+		server.IdleTimeout != 4*time.Second || server.MaxHeaderBytes != 1024 || server.TLSConfig != nil {
+		t.Error("config is not applied", server)
+	}
+
+	s = NewRestServer(":8080", &HttpConfig{TLS: TLSConfig{CertFile: "missing.crt", KeyFile: "missing.key"}})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("missing certificate is accepted")
+			}
+		}()
+		s.httpServer()
+	}()
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil, true)
+	serverCert := issueCert(t, dir, "server", ca, false)
+	clientCert := issueCert(t, dir, "client", ca, false)
+
+	addr, redirectAddr := freeAddr(t), freeAddr(t)
+	s := NewRestServer(addr, &HttpConfig{TLS: TLSConfig{CertFile: serverCert.certFile, KeyFile: serverCert.keyFile,
+		MinVersion: "1.3", ClientCAFile: ca.certFile, RedirectAddr: redirectAddr}})
+	s.GET("/hello", func(ctx *common.RequestCtx) interface{} {
+		return "hello " + ctx.Request.TLS.PeerCertificates[0].Subject.CommonName
+	})
+	s.StartServerAsync()
+	defer s.Lifecycle().Shutdown()
+	waitListening(t, addr)
+	waitListening(t, redirectAddr)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true,
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}},
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	}
+
+	// mutual tls
+	if resp, e := client().Get("https://" + addr + "/hello"); e == nil {
+		_ = resp.Body.Close()
+		t.Error("client without certificate is accepted")
+	}
+	pair, e := tls.LoadX509KeyPair(clientCert.certFile, clientCert.keyFile)
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp, e := client(pair).Get("https://" + addr + "/hello")
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 || resp.TLS.Version != tls.VersionTLS13 {
+		t.Error("unexpected response", resp.Status, resp.Proto, resp.TLS.Version)
+	}
+
+	// http is redirected to https
+	resp, e = client().Get("http://" + redirectAddr + "/hello?x=1")
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != "https://"+addr+"/hello?x=1" {
+		t.Error("unexpected redirect", resp.Status, resp.Header.Get("Location"))
+	}
+}
@@ -0,0 +1,344 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ValidatorFunc reports whether the field satisfies the rule,
+// param is the text after '=' in the tag, eg: "64" of `validate:"max=64"`
+type ValidatorFunc func(v reflect.Value, param string) bool
+
+// FieldError describes a field failing a rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned if the bound input of a handler is invalid,
+// it is responded as 400 Bad Request listing every failing field
+type ValidationError struct {
+	Fields []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// Validator checks struct fields tagged like `validate:"required,min=1,max=64,email"`
+type Validator struct {
+	sync.RWMutex
+	rules map[string]ValidatorFunc
+}
+
+func NewValidator() *Validator {
+	v := &Validator{rules: map[string]ValidatorFunc{}}
+	for name, rule := range builtinRules {
+		v.rules[name] = rule
+	}
+	return v
+}
+
+// register a custom rule, built-in rules can be overridden
+func (v *Validator) AddRule(name string, rule ValidatorFunc) {
+	v.Lock()
+	v.rules[name] = rule
+	v.Unlock()
+}
+
+// validate the struct (or the pointer to it), nil is returned if it is valid,
+// otherwise the error is a *ValidationError
+func (v *Validator) Validate(s interface{}) error {
+	value := reflect.ValueOf(s)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("only struct can be validated but got %T", s)
+	}
+
+	errs := &ValidationError{}
+	if e := v.validateStruct(value, "", errs); e != nil {
+		return e
+	}
+	if len(errs.Fields) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check that every rule named by the validate tags of the struct type is registered,
+// so that a typo is reported when the handler is mapped rather than by every request
+func (v *Validator) Check(t reflect.Type) error {
+	return v.checkStruct(t, map[reflect.Type]bool{})
+}
+
+func (v *Validator) checkStruct(t reflect.Type, visited map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.NumField() == 0 || t.PkgPath() == "time" || visited[t] {
+		return nil
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue //unexported
+		}
+		if tag := field.Tag.Get("validate"); tag != "" && tag != "-" {
+			for _, r := range parseRules(tag) {
+				v.RLock()
+				fn := v.rules[r.name]
+				v.RUnlock()
+				if fn == nil {
+					return fmt.Errorf("unknown validation rule `%s` of field `%s.%s`", r.name, t.Name(), field.Name)
+				}
+			}
+		}
+		if e := v.checkStruct(field.Type, visited); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (v *Validator) validateStruct(value reflect.Value, prefix string, errs *ValidationError) error {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue //unexported
+		}
+		f := value.Field(i)
+
+		name := prefix + fieldName(field)
+		if field.Anonymous {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+		if e := v.validateField(f, name, field.Tag.Get("validate"), errs); e != nil {
+			return e
+		}
+
+		// nested structs are validated as well
+		for f.Kind() == reflect.Ptr && !f.IsNil() {
+			f = f.Elem()
+		}
+		if f.Kind() == reflect.Struct && f.Type().NumField() > 0 && f.Type().PkgPath() != "time" {
+			nested := name + "."
+			if name == "" {
+				nested = ""
+			}
+			if e := v.validateStruct(f, nested, errs); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (v *Validator) validateField(f reflect.Value, name string, tag string, errs *ValidationError) error {
+	if tag == "" || tag == "-" {
+		return nil
+	}
+
+	for _, r := range parseRules(tag) {
+		rule, param := r.name, r.param
+
+		// rules other than required are skipped for nil values
+		if rule != "required" && isNil(f) {
+			continue
+		}
+
+		v.RLock()
+		fn := v.rules[rule]
+		v.RUnlock()
+		if fn == nil {
+			return fmt.Errorf("unknown validation rule `%s` of field `%s`", rule, name)
+		}
+
+		if !fn(indirect(f), param) {
+			errs.Fields = append(errs.Fields, FieldError{Field: name, Rule: rule, Param: param,
+				Message: fieldMessage(name, rule, param)})
+			// report the first failing rule of a field only
+			return nil
+		}
+	}
+	return nil
+}
+
+type tagRule struct {
+	name  string
+	param string
+}
+
+// rules of a validate tag separated by commas, regexp takes the rest of the tag
+// since its pattern may contain commas, eg: `validate:"required,regexp=^a{1,3}$"`
+func parseRules(tag string) []tagRule {
+	var rules []tagRule
+	for tag != "" {
+		part := tag
+		if strings.HasPrefix(strings.TrimSpace(tag), "regexp=") {
+			tag = ""
+		} else if i := strings.IndexByte(tag, ','); i >= 0 {
+			part, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+		r := tagRule{name: strings.TrimSpace(part)}
+		if i := strings.IndexByte(r.name, '='); i >= 0 {
+			r.name, r.param = r.name[:i], r.name[i+1:]
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// name of the field in the request: the name of the json, path, query or header tag
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "path", "query", "header"} {
+		if tag := strings.Split(field.Tag.Get(key), ",")[0]; tag != "" && tag != "-" {
+			return tag
+		}
+	}
+	return field.Name
+}
+
+func fieldMessage(name string, rule string, param string) string {
+	switch rule {
+	case "required":
+		return fmt.Sprintf("%s is required", name)
+	case "min":
+		return fmt.Sprintf("%s must be at least %s", name, param)
+	case "max":
+		return fmt.Sprintf("%s must be at most %s", name, param)
+	case "len":
+		return fmt.Sprintf("%s must have a length of %s", name, param)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of [%s]", name, param)
+	case "regexp":
+		return fmt.Sprintf("%s must match %s", name, param)
+	}
+	if param != "" {
+		return fmt.Sprintf("%s must satisfy %s=%s", name, rule, param)
+	}
+	return fmt.Sprintf("%s must be a valid %s", name, rule)
+}
+
+func isNil(f reflect.Value) bool {
+	switch f.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return f.IsNil()
+	}
+	return false
+}
+
+func indirect(f reflect.Value) reflect.Value {
+	for (f.Kind() == reflect.Ptr || f.Kind() == reflect.Interface) && !f.IsNil() {
+		f = f.Elem()
+	}
+	return f
+}
+
+// compare numbers by value, strings, slices and maps by length
+func compare(v reflect.Value, param string, ok func(n float64, limit float64) bool) bool {
+	limit, e := strconv.ParseFloat(param, 64)
+	if e != nil {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return ok(float64(len([]rune(v.String()))), limit)
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return ok(float64(v.Len()), limit)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ok(float64(v.Int()), limit)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ok(float64(v.Uint()), limit)
+	case reflect.Float32, reflect.Float64:
+		return ok(v.Float(), limit)
+	}
+	return false
+}
+
+func matchString(r *regexp.Regexp) ValidatorFunc {
+	return func(v reflect.Value, param string) bool {
+		return v.Kind() == reflect.String && r.MatchString(v.String())
+	}
+}
+
+var regexpCache sync.Map
+
+var builtinRules = map[string]ValidatorFunc{
+	"required": func(v reflect.Value, param string) bool {
+		return v.IsValid() && !isNil(v) && !v.IsZero()
+	},
+	"min": func(v reflect.Value, param string) bool {
+		return compare(v, param, func(n float64, limit float64) bool { return n >= limit })
+	},
+	"max": func(v reflect.Value, param string) bool {
+		return compare(v, param, func(n float64, limit float64) bool { return n <= limit })
+	},
+	"len": func(v reflect.Value, param string) bool {
+		return compare(v, param, func(n float64, limit float64) bool { return n == limit })
+	},
+	"oneof": func(v reflect.Value, param string) bool {
+		s := fmt.Sprint(v.Interface())
+		for _, candidate := range strings.Fields(param) {
+			if s == candidate {
+				return true
+			}
+		}
+		return false
+	},
+	"email": func(v reflect.Value, param string) bool {
+		if v.Kind() != reflect.String {
+			return false
+		}
+		addr, e := mail.ParseAddress(v.String())
+		return e == nil && addr.Address == v.String()
+	},
+	"url": func(v reflect.Value, param string) bool {
+		if v.Kind() != reflect.String {
+			return false
+		}
+		u, e := url.Parse(v.String())
+		return e == nil && u.Scheme != "" && u.Host != ""
+	},
+	"regexp": func(v reflect.Value, param string) bool {
+		r, cached := regexpCache.Load(param)
+		if !cached {
+			compiled, e := regexp.Compile(param)
+			if e != nil {
+				return false
+			}
+			r, _ = regexpCache.LoadOrStore(param, compiled)
+		}
+		return v.Kind() == reflect.String && r.(*regexp.Regexp).MatchString(v.String())
+	},
+	"uuid":    matchString(uuidRegexp),
+	"alpha":   matchString(regexp.MustCompile("^[a-zA-Z]+$")),
+	"alnum":   matchString(regexp.MustCompile("^[a-zA-Z0-9]+$")),
+	"numeric": matchString(regexp.MustCompile("^[-+]?[0-9]+(\\.[0-9]+)?$")),
+}
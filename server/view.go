@@ -0,0 +1,248 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+const (
+	// templates under these directories are shared by all views
+	layoutDir  = "layouts"
+	partialDir = "partials"
+	// the template of the view rendered by a layout, eg: {{template "content" .}}
+	contentTemplate = "content"
+)
+
+type ViewConfig struct {
+	// extension of the template files, .html by default
+	Ext string
+	// layout applied to the views, eg: layouts/main, views are rendered alone if empty
+	Layout string
+	// parse the templates on every render for development, otherwise they are parsed once
+	Reload bool
+}
+
+// ViewResult returned by handlers is rendered by the ViewEngine of the server as text/html
+type ViewResult struct {
+	Name   string
+	Data   interface{}
+	Layout string
+	// render without layout even if there is a default one
+	noLayout bool
+}
+
+// render the view named by its path under the template directory without extension, eg: users/show
+func View(name string, data interface{}) *ViewResult {
+	return &ViewResult{Name: name, Data: data}
+}
+
+// render with a layout other than the default one
+func (v *ViewResult) WithLayout(layout string) *ViewResult {
+	v.Layout = layout
+	return v
+}
+
+// render without any layout, eg: fragments for ajax
+func (v *ViewResult) WithoutLayout() *ViewResult {
+	v.noLayout = true
+	return v
+}
+
+// ViewEngine parses the templates of a file system, each view is parsed together with the
+// layouts (layouts/*) and partials (partials/*), templates are named by their path without extension
+type ViewEngine struct {
+	fsys   fs.FS
+	config ViewConfig
+	funcs  template.FuncMap
+
+	mu     sync.RWMutex
+	loaded bool
+	views  map[string]*template.Template
+}
+
+func NewViewEngine(fsys fs.FS, config ViewConfig) *ViewEngine {
+	if config.Ext == "" {
+		config.Ext = ".html"
+	}
+	return &ViewEngine{fsys: fsys, config: config, funcs: template.FuncMap{}}
+}
+
+// add funcs used by the templates, they must be added before the templates are loaded
+func (v *ViewEngine) Funcs(funcs template.FuncMap) *ViewEngine {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for name, fn := range funcs {
+		v.funcs[name] = fn
+	}
+	return v
+}
+
+// parse all the templates, called when the server starts unless Reload is set
+func (v *ViewEngine) Load() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.load()
+}
+
+func (v *ViewEngine) load() error {
+	base, pages, e := v.files()
+	if e != nil {
+		return e
+	}
+	views := make(map[string]*template.Template, len(pages))
+	for _, page := range pages {
+		t, e := v.parse(base, page)
+		if e != nil {
+			return e
+		}
+		views[v.name(page)] = t
+	}
+	v.views = views
+	v.loaded = true
+	return nil
+}
+
+// shared templates and views
+func (v *ViewEngine) files() (base []string, pages []string, e error) {
+	e = fs.WalkDir(v.fsys, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() || !strings.HasSuffix(p, v.config.Ext) {
+			return nil
+		}
+		if strings.HasPrefix(p, layoutDir+"/") || strings.HasPrefix(p, partialDir+"/") {
+			base = append(base, p)
+		} else {
+			pages = append(pages, p)
+		}
+		return nil
+	})
+	return
+}
+
+func (v *ViewEngine) name(file string) string {
+	return strings.TrimSuffix(file, v.config.Ext)
+}
+
+// the view is parsed after the shared templates, so that it can override their blocks
+func (v *ViewEngine) parse(base []string, page string) (*template.Template, error) {
+	t := template.New("").Funcs(v.funcs)
+	for _, file := range append(base, page) {
+		b, e := fs.ReadFile(v.fsys, file)
+		if e != nil {
+			return nil, e
+		}
+		if _, e := t.New(v.name(file)).Parse(string(b)); e != nil {
+			return nil, e
+		}
+	}
+	if _, e := t.New(contentTemplate).Parse(fmt.Sprintf(`{{template %q .}}`, v.name(page))); e != nil {
+		return nil, e
+	}
+	return t, nil
+}
+
+func (v *ViewEngine) lookup(name string) (*template.Template, error) {
+	if v.config.Reload {
+		v.mu.RLock()
+		defer v.mu.RUnlock()
+		base, _, e := v.files()
+		if e != nil {
+			return nil, e
+		}
+		page := name + v.config.Ext
+		if _, e := fs.Stat(v.fsys, page); e != nil {
+			return nil, fmt.Errorf("view `%s` is not found", name)
+		}
+		return v.parse(base, page)
+	}
+
+	v.mu.RLock()
+	loaded := v.loaded
+	v.mu.RUnlock()
+	if !loaded {
+		v.mu.Lock()
+		if !v.loaded {
+			if e := v.load(); e != nil {
+				v.mu.Unlock()
+				return nil, e
+			}
+		}
+		v.mu.Unlock()
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	t := v.views[name]
+	if t == nil {
+		return nil, fmt.Errorf("view `%s` is not found", name)
+	}
+	return t, nil
+}
+
+// render the view with its layout
+func (v *ViewEngine) Render(w io.Writer, view *ViewResult) error {
+	t, e := v.lookup(view.Name)
+	if e != nil {
+		return e
+	}
+	layout := view.Layout
+	if layout == "" && !view.noLayout {
+		layout = v.config.Layout
+	}
+	if layout == "" || view.noLayout {
+		return t.ExecuteTemplate(w, view.Name, view.Data)
+	}
+	if t.Lookup(layout) == nil {
+		return fmt.Errorf("layout `%s` is not found", layout)
+	}
+	return t.ExecuteTemplate(w, layout, view.Data)
+}
+
+// render the views returned by handlers, the func urlFor of the server is added to the templates,
+// eg: {{urlFor "user.show" "id" .Id}}
+func (s *RestServer) SetViewEngine(engine *ViewEngine) {
+	engine.Funcs(template.FuncMap{"urlFor": func(name string, params ...interface{}) (string, error) {
+		if len(params)%2 != 0 {
+			return "", errors.New("urlFor requires pairs of placeholder and value")
+		}
+		values := make(map[string]string, len(params)/2)
+		for i := 0; i < len(params); i += 2 {
+			values[fmt.Sprint(params[i])] = fmt.Sprint(params[i+1])
+		}
+		return s.URLFor(name, values, nil)
+	}})
+	s.controller.views = engine
+	for _, set := range s.routeSets {
+		set.controller.views = engine
+	}
+}
+
+func (s *RestServer) ViewEngine() *ViewEngine {
+	return s.controller.views
+}
+
+// rendered into a buffer, so that a failed template does not leave a partial page
+func (c *RestController) renderView(view *ViewResult) ([]byte, error) {
+	if c.views == nil {
+		return nil, errors.New("no view engine is set to render " + view.Name)
+	}
+	buf := &bytes.Buffer{}
+	if e := c.views.Render(buf, view); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
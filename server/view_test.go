@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"github.com/azzill/goze/common"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestView(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/main.html": {Data: []byte(`<title>{{block "title" .}}Goze{{end}}</title>{{template "partials/nav" .}}<main>{{template "content" .}}</main>`)},
+		"partials/nav.html": {Data: []byte(`<nav>{{shout "menu"}}</nav>`)},
+		"users/show.html":   {Data: []byte(`{{define "title"}}User {{.Name}}{{end}}<a href="{{urlFor "user.show" "id" .Id}}">{{.Name}}</a>`)},
+		"users/list.html":   {Data: []byte(`{{range .}}<li>{{.}}</li>{{end}}`)},
+		"broken.html":       {Data: []byte(`{{.Missing.Field}}`)},
+	}
+	s := NewRestServer(":8080", nil)
+	engine := NewViewEngine(fsys, ViewConfig{Layout: "layouts/main"})
+	engine.Funcs(template.FuncMap{"shout": strings.ToUpper})
+	s.SetViewEngine(engine)
+
+	type user struct {
+		Id   int
+		Name string
+	}
+	s.GET("/users/{id:int}", func(ctx *common.RequestCtx) interface{} {
+		return View("users/show", user{Id: ctx.PathInt("id"), Name: "<azz>"})
+	}, Named("user.show"))
+	s.GET("/users", func(ctx *common.RequestCtx) interface{} {
+		return View("users/list", []string{"a", "b"}).WithoutLayout()
+	})
+	s.GET("/broken", func(ctx *common.RequestCtx) interface{} {
+		return View("broken", 1)
+	})
+	s.GET("/missing", func(ctx *common.RequestCtx) interface{} {
+		return View("missing", nil)
+	})
+
+	wr := serve(s, "GET", "/users/42", nil)
+	expected := `<title>User &lt;azz&gt;</title><nav>MENU</nav><main><a href="/users/42">&lt;azz&gt;</a></main>`
+	if wr.Code != http.StatusOK || wr.Body.String() != expected || wr.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("unexpected view %d %q", wr.Code, wr.Body.String())
+	}
+	if wr := serve(s, "GET", "/users", nil); wr.Body.String() != "<li>a</li><li>b</li>" {
+		t.Errorf("unexpected view without layout %q", wr.Body.String())
+	}
+	// failed templates do not leave partial pages
+	for _, url := range []string{"/broken", "/missing"} {
+		if wr := serve(s, "GET", url, nil); wr.Code != http.StatusInternalServerError ||
+			wr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Error(url, "responded with", wr.Code, wr.Body.String())
+		}
+	}
+}
+
+func TestViewReload(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "index.tmpl")
+	_ = os.WriteFile(page, []byte("v1"), 0644)
+
+	s := NewRestServer(":8080", nil)
+	s.SetViewEngine(NewViewEngine(os.DirFS(dir), ViewConfig{Ext: ".tmpl", Reload: true}))
+	s.GET("/", func(ctx *common.RequestCtx) interface{} {
+		return View("index", nil)
+	})
+
+	if wr := serve(s, "GET", "/", nil); wr.Body.String() != "v1" {
+		t.Error("unexpected view", wr.Body.String())
+	}
+	_ = os.WriteFile(page, []byte("v2"), 0644)
+	if wr := serve(s, "GET", "/", nil); wr.Body.String() != "v2" {
+		t.Error("view is not reloaded", wr.Body.String())
+	}
+}
@@ -0,0 +1,493 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/azzill/goze/common"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocketHandler serves a websocket connection, the connection is closed when it returns
+type WebSocketHandler func(conn *WebSocketConn)
+
+type MessageType int
+
+// data message types, the same as the opcodes of RFC 6455
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// close codes of RFC 6455
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// max size of a message read, can be changed by SetReadLimit
+	DefaultMaxMessageSize = 1 << 20
+	// time to wait for the close frame of the peer
+	closeTimeout = 5 * time.Second
+)
+
+// CloseError is returned by ReadMessage after the connection is closed by the peer
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+var errWebSocketClosed = errors.New("websocket: use of closed connection")
+
+// WebSocketConn is an upgraded connection, messages may be read by one goroutine and
+// written by others concurrently, pings are answered while reading
+type WebSocketConn struct {
+	QueryString  map[string][]string
+	PathVariable map[string]string
+	Request      *http.Request
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	readLimit int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+	closed    bool
+}
+
+// map a websocket endpoint, the upgrade request goes through the interceptors like the other GET routes,
+// only the handshakes from the same origin are accepted unless CheckOrigin is given
+func (s *RestServer) WebSocket(pattern string, handler WebSocketHandler, options ...RouteOption) *RestServer {
+	s.mapping(Get, pattern, upgradeHandler(handler, options), nil, webSocketOptions(handler, options))
+	return s
+}
+
+func (g *RouteGroup) WebSocket(pattern string, handler WebSocketHandler, options ...RouteOption) *RouteGroup {
+	g.server.mapping(Get, joinPattern(g.prefix, pattern), upgradeHandler(handler, options), g,
+		webSocketOptions(handler, options))
+	return g
+}
+
+// decide whether the websocket handshake is accepted from the Origin of the request,
+// eg: a list of trusted sites, the handshake is refused with 403 Forbidden otherwise
+func CheckOrigin(check func(r *http.Request) bool) RouteOption {
+	return func(r *route) {
+		r.checkOrigin = check
+	}
+}
+
+// the default origin policy, browsers send the Origin of the page opening the websocket,
+// so that cookies of the site cannot be used by other sites, clients other than browsers send none
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, e := url.Parse(origin)
+	if e != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// routes are listed with the name of the websocket handler instead of the upgrade closure
+func webSocketOptions(handler WebSocketHandler, options []RouteOption) []RouteOption {
+	name := funcName(handler)
+	return append([]RouteOption{func(r *route) { r.handlerName = name }}, options...)
+}
+
+func upgradeHandler(handler WebSocketHandler, options []RouteOption) RequestHandler {
+	// the options are read before the route exists
+	r := &route{}
+	for _, option := range options {
+		option(r)
+	}
+	checkOrigin := r.checkOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	return func(ctx *common.RequestCtx) interface{} {
+		conn, e := upgrade(ctx.ResponseWriter, ctx.Request, checkOrigin)
+		if e != nil {
+			return e
+		}
+		conn.QueryString = ctx.QueryString
+		conn.PathVariable = ctx.PathVariable
+
+		defer conn.closeNow()
+		handler(conn)
+		if e := conn.writeClose(CloseNormalClosure, ""); e != nil && e != errWebSocketClosed {
+			logger.Warn("Websocket close -", e.Error())
+		}
+		return nil
+	}
+}
+
+// the opening handshake of RFC 6455
+func upgrade(wr http.ResponseWriter, r *http.Request, checkOrigin func(r *http.Request) bool) (*WebSocketConn, error) {
+	// HEAD requests fall back to the GET route
+	if r.Method != http.MethodGet {
+		wr.Header().Set("Allow", http.MethodGet)
+		return nil, NewHTTPError(http.StatusMethodNotAllowed, r.Method+" is not allowed")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, BadRequest("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		wr.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, NewHTTPError(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, e := base64.StdEncoding.DecodeString(key); e != nil || len(decoded) != 16 {
+		return nil, BadRequest("invalid Sec-WebSocket-Key")
+	}
+	if !checkOrigin(r) {
+		return nil, Forbidden("websocket origin is not allowed")
+	}
+
+	hijacker, ok := wr.(http.Hijacker)
+	if !ok {
+		return nil, InternalServerError("websocket is not supported by the connection")
+	}
+	conn, rw, e := hijacker.Hijack()
+	if e != nil {
+		return nil, e
+	}
+	// the deadlines of the http server do not apply to websockets
+	_ = conn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, e := rw.WriteString(response); e != nil {
+		_ = conn.Close()
+		return nil, e
+	}
+	if e := rw.Flush(); e != nil {
+		_ = conn.Close()
+		return nil, e
+	}
+	return &WebSocketConn{Request: r, conn: conn, reader: rw.Reader, readLimit: DefaultMaxMessageSize}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(header http.Header, key string, token string) bool {
+	for _, value := range header[key] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// max size of the messages read, the connection is closed with 1009 if a message is larger
+func (c *WebSocketConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+func (c *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// read the next data message, control frames are handled in place,
+// *CloseError is returned after the close handshake
+func (c *WebSocketConn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	for {
+		fin, opcode, payload, e := c.readFrame()
+		if e != nil {
+			return 0, nil, e
+		}
+
+		switch opcode {
+		case opPing:
+			// no pong after the close frame is sent
+			if e := c.writeFrame(opPong, payload); e != nil && e != errWebSocketClosed {
+				return 0, nil, e
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.onClose(payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected data frame in a fragmented message")
+			}
+			messageType = MessageType(opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		if int64(len(message)+len(payload)) > c.readLimit {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid utf-8 text")
+		}
+		return messageType, message, nil
+	}
+}
+
+func (c *WebSocketConn) ReadText() (string, error) {
+	_, message, e := c.ReadMessage()
+	return string(message), e
+}
+
+func (c *WebSocketConn) ReadJSON(v interface{}) error {
+	_, message, e := c.ReadMessage()
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(message, v)
+}
+
+func (c *WebSocketConn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return c.writeFrame(byte(messageType), data)
+}
+
+func (c *WebSocketConn) WriteText(text string) error {
+	return c.writeFrame(opText, []byte(text))
+}
+
+func (c *WebSocketConn) WriteJSON(v interface{}) error {
+	b, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	return c.writeFrame(opText, b)
+}
+
+func (c *WebSocketConn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("websocket: ping payload too long")
+	}
+	return c.writeFrame(opPing, data)
+}
+
+// start the close handshake, the close frame of the peer is returned by ReadMessage as *CloseError,
+// the connection is closed when the handler returns or the peer does not answer in time
+func (c *WebSocketConn) Close(code int, reason string) error {
+	if e := c.writeClose(code, reason); e != nil {
+		return e
+	}
+	return c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+}
+
+func (c *WebSocketConn) readFrame() (fin bool, opcode byte, payload []byte, e error) {
+	header := make([]byte, 2)
+	if _, e = io.ReadFull(c.reader, header); e != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		e = c.fail(CloseProtocolError, "reserved bits are set")
+		return
+	}
+	if header[1]&0x80 == 0 {
+		e = c.fail(CloseProtocolError, "frames from clients must be masked")
+		return
+	}
+
+	length := uint64(header[1] & 0x7f)
+	control := opcode&0x8 != 0
+	if control && (length > 125 || !fin) {
+		e = c.fail(CloseProtocolError, "invalid control frame")
+		return
+	}
+	switch length {
+	case 126:
+		b := make([]byte, 2)
+		if _, e = io.ReadFull(c.reader, b); e != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, e = io.ReadFull(c.reader, b); e != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(b)
+	}
+	// checked before reading, so that a large length can not exhaust the memory
+	if length > uint64(c.readLimit) {
+		e = c.fail(CloseMessageTooBig, "message too big")
+		return
+	}
+
+	mask := make([]byte, 4)
+	if _, e = io.ReadFull(c.reader, mask); e != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, e = io.ReadFull(c.reader, payload); e != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// answer the close frame of the peer and close the connection
+func (c *WebSocketConn) onClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, "invalid close reason")
+		}
+	} else if len(payload) == 1 {
+		return c.fail(CloseProtocolError, "invalid close frame")
+	}
+	_ = c.writeClose(closeErr.Code, "")
+	c.closeNow()
+	return closeErr
+}
+
+// codes that may be received in a close frame by RFC 6455 section 7.4, 1004, 1005, 1006 and 1015
+// are reserved, 3000-4999 are free for libraries and applications
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != CloseNoStatusReceived && code != 1006
+}
+
+// close the connection because of a protocol violation of the peer
+func (c *WebSocketConn) fail(code int, reason string) error {
+	_ = c.writeClose(code, reason)
+	c.closeNow()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (c *WebSocketConn) writeClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent || c.closed {
+		return errWebSocketClosed
+	}
+	c.closeSent = true
+	// codes that must not be sent in a close frame
+	if code == CloseNoStatusReceived || code < 1000 {
+		code = CloseNormalClosure
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	// the reason is cut at a rune boundary to stay valid UTF-8
+	if len(reason) > 123 {
+		n := 123
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+	return c.write(opClose, append(payload, reason...))
+}
+
+func (c *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent || c.closed {
+		return errWebSocketClosed
+	}
+	return c.write(opcode, payload)
+}
+
+// frames of the server are not masked nor fragmented
+func (c *WebSocketConn) write(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	_, e := c.conn.Write(append(frame, payload...))
+	return e
+}
+
+func (c *WebSocketConn) closeNow() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		_ = c.conn.Close()
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, addr string, path string, header string) (*wsClient, *http.Response) {
+	conn, e := net.Dial("tcp", addr)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := "GET " + path + " HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" + header + "\r\n"
+	if _, e := conn.Write([]byte(request)); e != nil {
+		t.Fatal(e)
+	}
+	reader := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(reader, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return &wsClient{conn: conn, reader: reader}, resp
+}
+
+// frames of clients are masked
+func (c *wsClient) send(fin bool, opcode byte, payload []byte) {
+	b := opcode
+	if fin {
+		b |= 0x80
+	}
+	frame := []byte{b}
+	if len(payload) <= 125 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, p := range payload {
+		frame = append(frame, p^mask[i%4])
+	}
+	_, _ = c.conn.Write(frame)
+}
+
+func (c *wsClient) receive(t *testing.T) (byte, []byte) {
+	header := make([]byte, 2)
+	if _, e := io.ReadFull(c.reader, header); e != nil {
+		t.Fatal(e)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		b := make([]byte, 2)
+		_, _ = io.ReadFull(c.reader, b)
+		length = int(binary.BigEndian.Uint16(b))
+	}
+	payload := make([]byte, length)
+	if _, e := io.ReadFull(c.reader, payload); e != nil {
+		t.Fatal(e)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestWebSocket(t *testing.T) {
+	s := NewRestServer(":8080", nil)
+	closed := make(chan *CloseError, 1)
+	api := s.Group("/api", &headerInterceptor{header: "X-Token"})
+	api.WebSocket("/echo/:room", func(conn *WebSocketConn) {
+		conn.SetReadLimit(16)
+		_ = conn.WriteText("joined " + conn.PathVariable["room"] + " as " + conn.QueryString["name"][0])
+		for {
+			messageType, message, e := conn.ReadMessage()
+			if e != nil {
+				ce, _ := e.(*CloseError)
+				closed <- ce
+				return
+			}
+			_ = conn.WriteMessage(messageType, message)
+		}
+	})
+	ts := httptest.NewServer(s.controller)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	// interceptors apply to the handshake
+	_, resp := dialWebSocket(t, addr, "/api/echo/lobby?name=azz", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Error("handshake is not intercepted", resp.StatusCode)
+	}
+
+	c, resp := dialWebSocket(t, addr, "/api/echo/lobby?name=azz", "X-Token: 1\r\n")
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("unexpected handshake", resp.StatusCode, resp.Header)
+	}
+	if op, payload := c.receive(t); op != opText || string(payload) != "joined lobby as azz" {
+		t.Error("unexpected greeting", op, string(payload))
+	}
+
+	// fragmented message with a ping in the middle
+	c.send(false, opText, []byte("hel"))
+	c.send(true, opPing, []byte("p"))
+	c.send(true, opContinuation, []byte("lo"))
+	if op, payload := c.receive(t); op != opPong || string(payload) != "p" {
+		t.Error("ping is not answered", op, string(payload))
+	}
+	if op, payload := c.receive(t); op != opText || string(payload) != "hello" {
+		t.Error("unexpected echo", op, string(payload))
+	}
+
+	// close handshake
+	c.send(true, opClose, []byte{0x03, 0xe8, 'b', 'y', 'e'})
+	if op, payload := c.receive(t); op != opClose || binary.BigEndian.Uint16(payload) != CloseNormalClosure {
+		t.Error("close is not answered", op, payload)
+	}
+	if ce := <-closed; ce == nil || ce.Code != CloseNormalClosure || ce.Reason != "bye" {
+		t.Error("unexpected close error", ce)
+	}
+
+	// size limit
+	c, _ = dialWebSocket(t, addr, "/api/echo/lobby?name=azz", "X-Token: 1\r\n")
+	c.receive(t)
+	c.send(true, opBinary, []byte(strings.Repeat("x", 200)))
+	if op, payload := c.receive(t); op != opClose || binary.BigEndian.Uint16(payload) != CloseMessageTooBig {
+		t.Error("large message is accepted", op, payload)
+	}
+	if ce := <-closed; ce == nil || ce.Code != CloseMessageTooBig {
+		t.Error("unexpected close error", ce)
+	}
+
+	// unmasked frames violate the protocol
+	c, _ = dialWebSocket(t, addr, "/api/echo/lobby?name=azz", "X-Token: 1\r\n")
+	c.receive(t)
+	_, _ = c.conn.Write([]byte{0x81, 0x01, 'x'})
+	if op, payload := c.receive(t); op != opClose || binary.BigEndian.Uint16(payload) != CloseProtocolError {
+		t.Error("unmasked frame is accepted", op, payload)
+	}
+	<-closed
+
+	// reserved close codes violate the protocol
+	for _, code := range []uint16{999, 1005, 1006, 1015, 2000} {
+		c, _ = dialWebSocket(t, addr, "/api/echo/lobby?name=azz", "X-Token: 1\r\n")
+		c.receive(t)
+		c.send(true, opClose, []byte{byte(code >> 8), byte(code)})
+		if op, payload := c.receive(t); op != opClose || binary.BigEndian.Uint16(payload) != CloseProtocolError {
+			t.Error("close code", code, "is accepted", op, payload)
+		}
+		<-closed
+	}
+
+	// handshakes from other sites are refused
+	_, resp = dialWebSocket(t, addr, "/api/echo/lobby?name=azz", "X-Token: 1\r\nOrigin: http://evil.com\r\n")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("cross origin handshake responded with", resp.StatusCode)
+	}
+	c, resp = dialWebSocket(t, addr, "/api/echo/lobby?name=azz", "X-Token: 1\r\nOrigin: http://"+addr+"\r\n")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Error("same origin handshake responded with", resp.StatusCode)
+	}
+	c.send(true, opClose, []byte{0x03, 0xe8})
+	<-closed
+
+	// long reasons are cut without splitting a rune
+	s.WebSocket("/bye", func(conn *WebSocketConn) {
+		_ = conn.Close(CloseGoingAway, strings.Repeat("é", 100))
+	})
+	c, _ = dialWebSocket(t, addr, "/bye", "")
+	if op, payload := c.receive(t); op != opClose || len(payload) != 2+122 || !utf8.Valid(payload[2:]) {
+		t.Errorf("unexpected close reason %q", payload)
+	}
+
+	// plain requests are rejected
+	if wr := serve(s, "GET", "/api/echo/lobby", map[string]string{"X-Token": "1"}); wr.Code != http.StatusBadRequest {
+		t.Error("plain request responded with", wr.Code)
+	}
+	if wr := serve(s, "HEAD", "/api/echo/lobby", map[string]string{"X-Token": "1"}); wr.Code != http.StatusMethodNotAllowed {
+		t.Error("HEAD request responded with", wr.Code)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	s := NewRestServer(":8080", nil)
+	s.WebSocket("/ws", func(conn *WebSocketConn) {}, CheckOrigin(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://app.goze.io"
+	}))
+	ts := httptest.NewServer(s.controller)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	if _, resp := dialWebSocket(t, addr, "/ws", "Origin: https://app.goze.io\r\n"); resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Error("trusted origin responded with", resp.StatusCode)
+	}
+	if _, resp := dialWebSocket(t, addr, "/ws", "Origin: http://"+addr+"\r\n"); resp.StatusCode != http.StatusForbidden {
+		t.Error("untrusted origin responded with", resp.StatusCode)
+	}
+}
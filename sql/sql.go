@@ -36,6 +36,11 @@ func NewSQL(dataSource string, driver string) *SQL {
 	return s
 }
 
+// close the connection pool, called when the application shuts down
+func (s *SQL) Close() error {
+	return s.Db.Close()
+}
+
 func (s *SQL) BeginTx() *Tx {
 	if tx, e := s.Db.Begin(); e != nil {
 		panic(e.Error())